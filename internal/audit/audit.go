@@ -0,0 +1,263 @@
+// Package audit keeps an append-only log of security relevant events.
+//
+// Events are written as JSON lines. Every event carries the hash of the
+// event before it, and its own hash covers that, so editing, removing or
+// reordering earlier events breaks the chain and is found by Verify.
+package audit
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Event types
+const (
+	UserCreated           = "user.created"
+	UserDeleted           = "user.deleted"
+	UserPurged            = "user.purged"
+	UserReactivated       = "user.reactivated"
+	UserUpgraded          = "user.upgraded"
+	UserSuspended         = "user.suspended"
+	UserUnsuspended       = "user.unsuspended"
+	UserRoleChanged       = "user.role_changed"
+	UserUnlocked          = "user.unlocked"
+	LoginSucceeded        = "login.succeeded"
+	LoginFailed           = "login.failed"
+	PasswordChanged       = "password.changed"
+	PasswordReset         = "password.reset"
+	EmailChanged          = "email.changed"
+	EmailVerified         = "email.verified"
+	TwoFactorEnabled      = "two_factor.enabled"
+	TwoFactorDisabled     = "two_factor.disabled"
+	RecoveryCodesReplaced = "two_factor.recovery_codes_replaced"
+	TokenRevoked          = "token.revoked"
+	SessionRevoked        = "session.revoked"
+	SessionsRevoked       = "session.revoked_all"
+	PersonalTokenCreated  = "personal_token.created"
+	PersonalTokenRevoked  = "personal_token.revoked"
+	OAuthClientCreated    = "oauth_client.created"
+	OAuthClientDeleted    = "oauth_client.deleted"
+	ChirpRemoved          = "chirp.removed"
+)
+
+// Event is a single entry of the log
+type Event struct {
+	Seq  int64     `json:"seq"`
+	Time time.Time `json:"time"`
+	Type string    `json:"type"`
+	// ActorId is the user who did it, 0 for anonymous requests and the system
+	ActorId int `json:"actor_id"`
+	// TargetId is the user it was done to
+	TargetId  int               `json:"target_id,omitempty"`
+	IP        string            `json:"ip,omitempty"`
+	UserAgent string            `json:"user_agent,omitempty"`
+	Details   map[string]string `json:"details,omitempty"`
+	PrevHash  string            `json:"prev_hash"`
+	Hash      string            `json:"hash"`
+}
+
+// Filter selects events, zero fields match every event
+type Filter struct {
+	Type     string
+	ActorId  int
+	TargetId int
+	IP       string
+	Since    time.Time
+	Until    time.Time
+	// Limit is the most events returned, the newest first
+	Limit int
+}
+
+func (f Filter) matches(e Event) bool {
+	return (f.Type == "" || e.Type == f.Type) &&
+		(f.ActorId == 0 || e.ActorId == f.ActorId) &&
+		(f.TargetId == 0 || e.TargetId == f.TargetId) &&
+		(f.IP == "" || e.IP == f.IP) &&
+		(f.Since.IsZero() || !e.Time.Before(f.Since)) &&
+		(f.Until.IsZero() || e.Time.Before(f.Until))
+}
+
+// Log is an audit log file
+type Log struct {
+	path     string
+	mux      sync.Mutex
+	lastSeq  int64
+	lastHash string
+}
+
+// Open opens the log at path, creating it if it doesn't exist
+func Open(path string) (*Log, error) {
+	l := &Log{path: path}
+
+	file, err := os.OpenFile(path, os.O_RDONLY|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	err = scan(file, func(e Event) error {
+		l.lastSeq = e.Seq
+		l.lastHash = e.Hash
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Record appends the event to the log. The sequence number,
+// the time if it is missing and the hashes are filled in
+func (l *Log) Record(e Event) error {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	e.Seq = l.lastSeq + 1
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	e.Time = e.Time.UTC()
+	e.PrevHash = l.lastHash
+	e.Hash = hash(e)
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(line, '\n'))
+	if err != nil {
+		return err
+	}
+	err = file.Sync()
+	if err != nil {
+		return err
+	}
+
+	l.lastSeq = e.Seq
+	l.lastHash = e.Hash
+	return nil
+}
+
+// Query returns the events matching the filter, the newest first
+func (l *Log) Query(filter Filter) ([]Event, error) {
+	file, err := os.Open(l.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	events := make([]Event, 0)
+	err = scan(file, func(e Event) error {
+		if filter.matches(e) {
+			events = append(events, e)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// newest first
+	for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+		events[i], events[j] = events[j], events[i]
+	}
+	if filter.Limit > 0 && len(events) > filter.Limit {
+		events = events[:filter.Limit]
+	}
+	return events, nil
+}
+
+// Verification is the result of checking the hash chain
+type Verification struct {
+	Valid  bool  `json:"valid"`
+	Events int64 `json:"events"`
+	// BrokenAt is the sequence number of the first event which doesn't fit the chain
+	BrokenAt int64  `json:"broken_at,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+var errChainBroken = errors.New("chain broken")
+
+// Verify walks the whole log and checks that no event was changed,
+// removed or reordered
+func (l *Log) Verify() (Verification, error) {
+	file, err := os.Open(l.path)
+	if err != nil {
+		return Verification{}, err
+	}
+	defer file.Close()
+
+	result := Verification{Valid: true}
+	prevHash := ""
+	err = scan(file, func(e Event) error {
+		result.Events++
+		switch {
+		case e.Seq != result.Events:
+			result.Reason = fmt.Sprintf("expected sequence number %d", result.Events)
+		case e.PrevHash != prevHash:
+			result.Reason = "previous hash doesn't match the event before"
+		case e.Hash != hash(e):
+			result.Reason = "hash doesn't match the event"
+		default:
+			prevHash = e.Hash
+			return nil
+		}
+		result.Valid = false
+		result.BrokenAt = result.Events
+		return errChainBroken
+	})
+	if err != nil && !errors.Is(err, errChainBroken) {
+		var syntaxErr *json.SyntaxError
+		if !errors.As(err, &syntaxErr) {
+			return Verification{}, err
+		}
+		result.Valid = false
+		result.BrokenAt = result.Events + 1
+		result.Reason = "event is not valid JSON"
+	}
+
+	return result, nil
+}
+
+// hash returns the hash of the event, which covers every field but the hash itself
+func hash(e Event) string {
+	e.Hash = ""
+	data, _ := json.Marshal(e)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// scan calls fn with every event of the file in order
+func scan(file *os.File, fn func(Event) error) error {
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var e Event
+		err := json.Unmarshal(scanner.Bytes(), &e)
+		if err != nil {
+			return err
+		}
+		err = fn(e)
+		if err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
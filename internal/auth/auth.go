@@ -0,0 +1,158 @@
+// Package auth issues and validates the JWTs of Chirpy.
+//
+// Every token carries a typ claim telling what it can be used for, so a
+// refresh token is never accepted where an access token is expected.
+// Parsing only accepts the algorithms of the keyring and tokens issued by
+// and for this Chirpy instance.
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/keyring"
+)
+
+type TokenType string
+
+const (
+	TokenTypeAccess  TokenType = "access"
+	TokenTypeRefresh TokenType = "refresh"
+	// TokenTypeMFA is the challenge token of a login waiting for its second factor
+	TokenTypeMFA TokenType = "mfa"
+)
+
+const (
+	DefaultIssuer   = "chirpy"
+	DefaultAudience = "chirpy-api"
+	DefaultLeeway   = 30 * time.Second
+)
+
+var (
+	ErrMissingToken     = errors.New("jwt token missing")
+	ErrMalformedHeader  = errors.New("malformed authorization header")
+	ErrInvalidToken     = errors.New("invalid token")
+	ErrInvalidTokenType = errors.New("wrong token type")
+)
+
+// Claims are the claims of the tokens Chirpy issues
+type Claims struct {
+	jwt.RegisteredClaims
+	Type TokenType `json:"typ"`
+	// SessionId is the id of the session the token was issued for
+	SessionId string `json:"sid,omitempty"`
+	// ClientId is the OAuth client the token was issued to,
+	// tokens of the first party apps have none
+	ClientId string `json:"client_id,omitempty"`
+	// Scope is the space separated list of scopes granted to the client
+	Scope string `json:"scope,omitempty"`
+}
+
+// UserId returns the id of the user the token was issued to
+func (c *Claims) UserId() (int, error) {
+	userId, err := strconv.Atoi(c.Subject)
+	if err != nil || userId <= 0 {
+		return 0, fmt.Errorf("%w: invalid subject", ErrInvalidToken)
+	}
+	return userId, nil
+}
+
+// Principal returns the principal the token was issued to. Tokens
+// issued to OAuth clients are limited to their scopes
+func (c *Claims) Principal() (Principal, error) {
+	userId, err := c.UserId()
+	if err != nil {
+		return Principal{}, err
+	}
+
+	principal := Principal{UserId: userId, SessionId: c.SessionId, ClientId: c.ClientId}
+	if c.ClientId != "" {
+		principal.Scopes = append([]string{}, strings.Fields(c.Scope)...)
+	}
+	return principal, nil
+}
+
+type Authenticator struct {
+	Keyring  *keyring.Keyring
+	Issuer   string
+	Audience string
+	// Leeway is the clock skew allowed when checking exp, nbf and iat
+	Leeway time.Duration
+}
+
+// New creates an authenticator with the default issuer and audience
+func New(keys *keyring.Keyring, leeway time.Duration) *Authenticator {
+	return &Authenticator{
+		Keyring:  keys,
+		Issuer:   DefaultIssuer,
+		Audience: DefaultAudience,
+		Leeway:   leeway,
+	}
+}
+
+// Sign issues a token with the claims which expires after ttl.
+// The issuer, audience and issue time are set by the authenticator
+func (a *Authenticator) Sign(claims Claims, ttl time.Duration) (string, error) {
+	now := time.Now().UTC()
+	claims.Issuer = a.Issuer
+	claims.Audience = jwt.ClaimStrings{a.Audience}
+	claims.IssuedAt = jwt.NewNumericDate(now)
+	claims.ExpiresAt = jwt.NewNumericDate(now.Add(ttl))
+
+	return a.Keyring.Sign(claims)
+}
+
+// Parse verifies the raw token and returns its claims. The token must be
+// signed by the keyring, issued by and for this instance, unexpired and of the type
+func (a *Authenticator) Parse(raw string, typ TokenType) (*Claims, error) {
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(raw, claims, a.Keyring.Keyfunc,
+		jwt.WithValidMethods(a.Keyring.Algorithms()),
+		jwt.WithIssuer(a.Issuer),
+		jwt.WithAudience(a.Audience),
+		jwt.WithLeeway(a.Leeway),
+		jwt.WithIssuedAt(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	if claims.ExpiresAt == nil || claims.IssuedAt == nil {
+		return nil, fmt.Errorf("%w: token has no expiry", ErrInvalidToken)
+	}
+	if claims.Subject == "" || claims.ID == "" {
+		return nil, fmt.Errorf("%w: token is missing required claims", ErrInvalidToken)
+	}
+	if claims.Type != typ {
+		return nil, fmt.Errorf("%w: expected %s token", ErrInvalidTokenType, typ)
+	}
+
+	return claims, nil
+}
+
+// BearerToken returns the token of a "Bearer <token>" authorization header
+func BearerToken(header http.Header) (string, error) {
+	return HeaderCredentials(header, "Bearer")
+}
+
+// HeaderCredentials returns the credentials of an authorization header
+// using the scheme. The scheme is matched case insensitively
+func HeaderCredentials(header http.Header, scheme string) (string, error) {
+	authHeader := header.Get("Authorization")
+	if authHeader == "" {
+		return "", ErrMissingToken
+	}
+
+	headerScheme, credentials, ok := strings.Cut(authHeader, " ")
+	credentials = strings.TrimSpace(credentials)
+	if !ok || !strings.EqualFold(headerScheme, scheme) || credentials == "" || strings.ContainsAny(credentials, " \t") {
+		return "", ErrMalformedHeader
+	}
+
+	return credentials, nil
+}
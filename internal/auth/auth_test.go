@@ -0,0 +1,331 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/keyring"
+)
+
+var testSecret = []byte("test secret")
+
+func newTestAuthenticator(t *testing.T) *Authenticator {
+	t.Helper()
+
+	key, err := keyring.NewHMACKey("k1", testSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := keyring.New(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(keys, DefaultLeeway)
+}
+
+// validClaims are the claims of an access token the test authenticator accepts
+func validClaims() Claims {
+	now := time.Now()
+	return Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    DefaultIssuer,
+			Audience:  jwt.ClaimStrings{DefaultAudience},
+			Subject:   "1",
+			ID:        "jti",
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+		},
+		Type: TokenTypeAccess,
+	}
+}
+
+// signToken signs the claims by hand, so tokens the authenticator would
+// never issue can be made
+func signToken(t *testing.T, method jwt.SigningMethod, kid string, key interface{}, claims Claims) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	raw, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func TestParse(t *testing.T) {
+	a := newTestAuthenticator(t)
+	hour := time.Hour
+
+	tests := []struct {
+		name    string
+		token   func() string
+		typ     TokenType
+		wantErr error
+	}{
+		{
+			name:  "valid access token",
+			token: func() string { return signToken(t, jwt.SigningMethodHS256, "k1", testSecret, validClaims()) },
+			typ:   TokenTypeAccess,
+		},
+		{
+			name: "token of Sign",
+			token: func() string {
+				raw, err := a.Sign(Claims{RegisteredClaims: jwt.RegisteredClaims{Subject: "1", ID: "jti"}, Type: TokenTypeRefresh}, hour)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return raw
+			},
+			typ: TokenTypeRefresh,
+		},
+		{
+			name: "refresh token used as access token",
+			token: func() string {
+				c := validClaims()
+				c.Type = TokenTypeRefresh
+				return signToken(t, jwt.SigningMethodHS256, "k1", testSecret, c)
+			},
+			typ:     TokenTypeAccess,
+			wantErr: ErrInvalidTokenType,
+		},
+		{
+			name: "mfa token used as access token",
+			token: func() string {
+				c := validClaims()
+				c.Type = TokenTypeMFA
+				return signToken(t, jwt.SigningMethodHS256, "k1", testSecret, c)
+			},
+			typ:     TokenTypeAccess,
+			wantErr: ErrInvalidTokenType,
+		},
+		{
+			name:    "wrong algorithm",
+			token:   func() string { return signToken(t, jwt.SigningMethodHS512, "k1", testSecret, validClaims()) },
+			typ:     TokenTypeAccess,
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name: "none algorithm",
+			token: func() string {
+				return signToken(t, jwt.SigningMethodNone, "k1", jwt.UnsafeAllowNoneSignatureType, validClaims())
+			},
+			typ:     TokenTypeAccess,
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:    "unknown kid",
+			token:   func() string { return signToken(t, jwt.SigningMethodHS256, "k2", testSecret, validClaims()) },
+			typ:     TokenTypeAccess,
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:    "missing kid without a default key",
+			token:   func() string { return signToken(t, jwt.SigningMethodHS256, "", testSecret, validClaims()) },
+			typ:     TokenTypeAccess,
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name: "wrong secret",
+			token: func() string {
+				return signToken(t, jwt.SigningMethodHS256, "k1", []byte("other secret"), validClaims())
+			},
+			typ:     TokenTypeAccess,
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name: "wrong issuer",
+			token: func() string {
+				c := validClaims()
+				c.Issuer = "someone-else"
+				return signToken(t, jwt.SigningMethodHS256, "k1", testSecret, c)
+			},
+			typ:     TokenTypeAccess,
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name: "wrong audience",
+			token: func() string {
+				c := validClaims()
+				c.Audience = jwt.ClaimStrings{"other-api"}
+				return signToken(t, jwt.SigningMethodHS256, "k1", testSecret, c)
+			},
+			typ:     TokenTypeAccess,
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name: "expired",
+			token: func() string {
+				c := validClaims()
+				c.IssuedAt = jwt.NewNumericDate(time.Now().Add(-2 * hour))
+				c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-hour))
+				return signToken(t, jwt.SigningMethodHS256, "k1", testSecret, c)
+			},
+			typ:     TokenTypeAccess,
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name: "expired within the leeway",
+			token: func() string {
+				c := validClaims()
+				c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-DefaultLeeway / 2))
+				return signToken(t, jwt.SigningMethodHS256, "k1", testSecret, c)
+			},
+			typ: TokenTypeAccess,
+		},
+		{
+			name: "issued in the future",
+			token: func() string {
+				c := validClaims()
+				c.IssuedAt = jwt.NewNumericDate(time.Now().Add(hour))
+				return signToken(t, jwt.SigningMethodHS256, "k1", testSecret, c)
+			},
+			typ:     TokenTypeAccess,
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name: "missing exp",
+			token: func() string {
+				c := validClaims()
+				c.ExpiresAt = nil
+				return signToken(t, jwt.SigningMethodHS256, "k1", testSecret, c)
+			},
+			typ:     TokenTypeAccess,
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name: "missing iat",
+			token: func() string {
+				c := validClaims()
+				c.IssuedAt = nil
+				return signToken(t, jwt.SigningMethodHS256, "k1", testSecret, c)
+			},
+			typ:     TokenTypeAccess,
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name: "missing sub",
+			token: func() string {
+				c := validClaims()
+				c.Subject = ""
+				return signToken(t, jwt.SigningMethodHS256, "k1", testSecret, c)
+			},
+			typ:     TokenTypeAccess,
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name: "missing jti",
+			token: func() string {
+				c := validClaims()
+				c.ID = ""
+				return signToken(t, jwt.SigningMethodHS256, "k1", testSecret, c)
+			},
+			typ:     TokenTypeAccess,
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name: "missing typ",
+			token: func() string {
+				c := validClaims()
+				c.Type = ""
+				return signToken(t, jwt.SigningMethodHS256, "k1", testSecret, c)
+			},
+			typ:     TokenTypeAccess,
+			wantErr: ErrInvalidTokenType,
+		},
+		{
+			name:    "not a jwt",
+			token:   func() string { return "not.a.jwt" },
+			typ:     TokenTypeAccess,
+			wantErr: ErrInvalidToken,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := a.Parse(tt.token(), tt.typ)
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("got error %v, want none", err)
+				}
+				if claims.Type != tt.typ {
+					t.Fatalf("got type %q, want %q", claims.Type, tt.typ)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHeaderCredentials(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		scheme  string
+		want    string
+		wantErr error
+	}{
+		{name: "bearer token", header: "Bearer abc", scheme: "Bearer", want: "abc"},
+		{name: "scheme in other case", header: "bearer abc", scheme: "Bearer", want: "abc"},
+		{name: "surrounding spaces", header: "Bearer  abc ", scheme: "Bearer", want: "abc"},
+		{name: "api key", header: "ApiKey abc", scheme: "ApiKey", want: "abc"},
+		{name: "missing header", header: "", scheme: "Bearer", wantErr: ErrMissingToken},
+		{name: "no scheme", header: "abc", scheme: "Bearer", wantErr: ErrMalformedHeader},
+		{name: "wrong scheme", header: "Basic abc", scheme: "Bearer", wantErr: ErrMalformedHeader},
+		{name: "scheme without token", header: "Bearer ", scheme: "Bearer", wantErr: ErrMalformedHeader},
+		{name: "token with spaces", header: "Bearer abc def", scheme: "Bearer", wantErr: ErrMalformedHeader},
+		{name: "token with tab", header: "Bearer abc\tdef", scheme: "Bearer", wantErr: ErrMalformedHeader},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			if tt.header != "" {
+				header.Set("Authorization", tt.header)
+			}
+
+			got, err := HeaderCredentials(header, tt.scheme)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClaimsUserId(t *testing.T) {
+	tests := []struct {
+		subject string
+		want    int
+		wantErr bool
+	}{
+		{subject: "42", want: 42},
+		{subject: "", wantErr: true},
+		{subject: "0", wantErr: true},
+		{subject: "-1", wantErr: true},
+		{subject: "abc", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.subject, func(t *testing.T) {
+			c := Claims{RegisteredClaims: jwt.RegisteredClaims{Subject: tt.subject}}
+			got, err := c.UserId()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Fatalf("got %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package auth
+
+import "context"
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	UserId int
+	// SessionId is the session the access token was issued for
+	SessionId string
+	// ClientId is the OAuth client acting on behalf of the user
+	ClientId string
+	// PersonalTokenId is the personal access token the request was made with
+	PersonalTokenId int
+	// Scopes limit what the principal is allowed to do, nil means no limit
+	Scopes []string
+}
+
+// IsAnonymous reports whether the request was made without a token
+func (p Principal) IsAnonymous() bool {
+	return p.UserId == 0
+}
+
+// HasScope reports whether the principal was granted the scope
+func (p Principal) HasScope(scope string) bool {
+	if p.Scopes == nil {
+		return !p.IsAnonymous()
+	}
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type principalKey struct{}
+
+// NewContext returns a copy of ctx carrying the principal
+func NewContext(ctx context.Context, principal Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// FromContext returns the principal of ctx. Requests without one
+// return the anonymous principal
+func FromContext(ctx context.Context) (Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(Principal)
+	return principal, ok
+}
@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Scopes limit what personal access tokens and third party apps can do
+const (
+	ScopeChirpsRead     = "chirps:read"
+	ScopeChirpsWrite    = "chirps:write"
+	ScopeProfileRead    = "profile:read"
+	ScopeProfileWrite   = "profile:write"
+	ScopeBookmarksRead  = "bookmarks:read"
+	ScopeBookmarksWrite = "bookmarks:write"
+	ScopeBlocksRead     = "blocks:read"
+	ScopeBlocksWrite    = "blocks:write"
+)
+
+var knownScopes = []string{
+	ScopeChirpsRead,
+	ScopeChirpsWrite,
+	ScopeProfileRead,
+	ScopeProfileWrite,
+	ScopeBookmarksRead,
+	ScopeBookmarksWrite,
+	ScopeBlocksRead,
+	ScopeBlocksWrite,
+}
+
+// PersonalTokenPrefix starts every personal access token, which tells
+// them apart from JWTs and makes leaked tokens easy to scan for
+const PersonalTokenPrefix = "chirpy_pat_"
+
+// KnownScopes returns every scope a token can be granted
+func KnownScopes() []string {
+	return append([]string(nil), knownScopes...)
+}
+
+// ValidateScopes checks that every scope is known and returns them without duplicates
+func ValidateScopes(scopes []string) ([]string, error) {
+	if len(scopes) == 0 {
+		return nil, fmt.Errorf("at least one scope is required, known scopes are %s", strings.Join(knownScopes, ", "))
+	}
+
+	seen := make(map[string]bool)
+	valid := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		if !isKnownScope(scope) {
+			return nil, fmt.Errorf("unknown scope %q", scope)
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			valid = append(valid, scope)
+		}
+	}
+
+	return valid, nil
+}
+
+func isKnownScope(scope string) bool {
+	for _, known := range knownScopes {
+		if scope == known {
+			return true
+		}
+	}
+	return false
+}
+
+// NewPersonalToken returns a new random personal access token
+func NewPersonalToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return PersonalTokenPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// IsPersonalToken reports whether the bearer token is a personal access token
+func IsPersonalToken(token string) bool {
+	return strings.HasPrefix(token, PersonalTokenPrefix)
+}
+
+// HashToken returns the hash opaque tokens are stored and looked up by
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
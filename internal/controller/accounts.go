@@ -0,0 +1,79 @@
+package controller
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/mustafa-mun/chirpy-bootdev/internal/audit"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/handler"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/password"
+)
+
+// DeleteMeHandler deletes the account of the logged in user.
+// The account is deactivated right away and purged after the grace period
+func (cfg *ApiConfig) DeleteMeHandler(w http.ResponseWriter, r *http.Request) {
+	userId := principal(r).UserId
+
+	type parameters struct {
+		Password string `json:"password"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		handler.RespondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+
+	user, err := db.GetUser(userId)
+	if err != nil {
+		respondWithUserError(w, err)
+		return
+	}
+
+	// Deleting an account always requires the password
+	err = password.Verify(user.Password, params.Password)
+	if err != nil {
+		handler.RespondWithError(w, http.StatusUnauthorized, "password is wrong")
+		return
+	}
+
+	deletedUser, err := db.DeactivateUser(userId, time.Now())
+	if err != nil {
+		respondWithUserError(w, err)
+		return
+	}
+	cfg.audit(r, audit.UserDeleted, userId, userId, nil)
+
+	type returnVals struct {
+		Status         string    `json:"status"`
+		ChirpRetention string    `json:"chirp_retention"`
+		PurgeAt        time.Time `json:"purge_at"`
+	}
+	respBody := returnVals{
+		Status:         "deleted",
+		ChirpRetention: string(cfg.ChirpRetention),
+		PurgeAt:        deletedUser.DeletedAt.Add(cfg.DeletionGracePeriod),
+	}
+
+	handler.RespondWithJSON(w, http.StatusOK, respBody)
+}
+
+// PurgeDeletedAccounts removes the accounts whose grace period is over
+func (cfg *ApiConfig) PurgeDeletedAccounts() {
+	purged, err := db.PurgeDeletedUsers(time.Now().Add(-cfg.DeletionGracePeriod), cfg.ChirpRetention)
+	if err != nil {
+		log.Printf("couldn't purge deleted accounts: %v", err)
+		return
+	}
+	for _, userId := range purged {
+		cfg.auditSystem(audit.UserPurged, userId, nil)
+	}
+	if len(purged) > 0 {
+		cfg.removeExports(purged)
+		log.Printf("purged %d deleted accounts", len(purged))
+	}
+}
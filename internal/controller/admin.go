@@ -0,0 +1,169 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/audit"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/database"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/handler"
+)
+
+// AdminUserVals is the view of a user moderators and admins get
+type AdminUserVals struct {
+	ReturnUserVals
+	SuspendedAt *time.Time `json:"suspended_at,omitempty"`
+}
+
+func newAdminUserVals(user database.User) AdminUserVals {
+	return AdminUserVals{
+		ReturnUserVals: newReturnUserVals(user),
+		SuspendedAt:    user.SuspendedAt,
+	}
+}
+
+// AdminGetUsersHandler lists every user
+func (cfg *ApiConfig) AdminGetUsersHandler(w http.ResponseWriter, r *http.Request) {
+	users, err := db.GetUsers()
+	if err != nil {
+		handler.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respBody := make([]AdminUserVals, 0, len(users))
+	for _, user := range users {
+		respBody = append(respBody, newAdminUserVals(user))
+	}
+
+	handler.RespondWithJSON(w, http.StatusOK, respBody)
+}
+
+// AdminSuspendUserHandler suspends an account and logs it out everywhere
+func (cfg *ApiConfig) AdminSuspendUserHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.handleSuspension(w, r, audit.UserSuspended, func(actor database.User, userId int) (database.User, error) {
+		return db.SuspendUser(actor, userId, time.Now())
+	})
+}
+
+// AdminUnsuspendUserHandler lifts the suspension of an account
+func (cfg *ApiConfig) AdminUnsuspendUserHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.handleSuspension(w, r, audit.UserUnsuspended, db.UnsuspendUser)
+}
+
+func (cfg *ApiConfig) handleSuspension(w http.ResponseWriter, r *http.Request, eventType string, update func(actor database.User, userId int) (database.User, error)) {
+	actor, err := db.GetUser(principal(r).UserId)
+	if err != nil {
+		respondWithUserError(w, err)
+		return
+	}
+
+	userId, err := strconv.Atoi(chi.URLParam(r, "userId"))
+	if err != nil {
+		handler.RespondWithError(w, http.StatusBadRequest, "invalid user id")
+		return
+	}
+
+	user, err := update(actor, userId)
+	if err != nil {
+		respondWithAdminError(w, err)
+		return
+	}
+	cfg.audit(r, eventType, actor.ID, user.ID, nil)
+
+	handler.RespondWithJSON(w, http.StatusOK, newAdminUserVals(user))
+}
+
+// AdminSetRoleHandler changes the role of a user
+func (cfg *ApiConfig) AdminSetRoleHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := strconv.Atoi(chi.URLParam(r, "userId"))
+	if err != nil {
+		handler.RespondWithError(w, http.StatusBadRequest, "invalid user id")
+		return
+	}
+
+	type parameters struct {
+		Role string `json:"role"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		handler.RespondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+
+	role, err := database.ParseRole(params.Role)
+	if err != nil {
+		handler.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Admins can't lock themselves out of the admin routes
+	if userId == principal(r).UserId && role != database.RoleAdmin {
+		handler.RespondWithError(w, http.StatusBadRequest, "you can't change your own role")
+		return
+	}
+
+	user, err := db.SetUserRole(userId, role)
+	if err != nil {
+		respondWithAdminError(w, err)
+		return
+	}
+	cfg.audit(r, audit.UserRoleChanged, principal(r).UserId, user.ID, map[string]string{"role": string(role)})
+
+	handler.RespondWithJSON(w, http.StatusOK, newAdminUserVals(user))
+}
+
+// AdminDeleteChirpHandler deletes any chirp regardless of its author
+func (cfg *ApiConfig) AdminDeleteChirpHandler(w http.ResponseWriter, r *http.Request) {
+	chirpId, err := strconv.Atoi(chi.URLParam(r, "chirpId"))
+	if err != nil {
+		handler.RespondWithError(w, http.StatusBadRequest, "invalid chirp id")
+		return
+	}
+
+	err = db.RemoveChirp(chirpId)
+	if err != nil {
+		respondWithAdminError(w, err)
+		return
+	}
+	cfg.audit(r, audit.ChirpRemoved, principal(r).UserId, 0, map[string]string{"chirp_id": strconv.Itoa(chirpId)})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// BootstrapAdmin makes the user with the email an admin, creating it
+// with the password if needed. It is used to set up the first admin
+func BootstrapAdmin(email, password string) error {
+	user, created, err := db.BootstrapAdmin(email, password)
+	if err != nil {
+		return fmt.Errorf("couldn't bootstrap admin: %w", err)
+	}
+
+	if created {
+		log.Printf("created admin %s with id %d", user.Email, user.ID)
+	} else {
+		log.Printf("promoted %s with id %d to admin", user.Email, user.ID)
+	}
+	return nil
+}
+
+func respondWithAdminError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, database.ErrUserNotFound), errors.Is(err, database.ErrChirpNotFound):
+		handler.RespondWithError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, database.ErrNotPermitted):
+		handler.RespondWithError(w, http.StatusForbidden, err.Error())
+	case errors.Is(err, database.ErrNotSuspended):
+		handler.RespondWithError(w, http.StatusConflict, err.Error())
+	default:
+		handler.RespondWithError(w, http.StatusInternalServerError, err.Error())
+	}
+}
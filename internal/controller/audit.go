@@ -0,0 +1,113 @@
+package controller
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mustafa-mun/chirpy-bootdev/internal/audit"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/handler"
+)
+
+// defaultAuditLimit is how many events the audit endpoint returns without a limit
+const defaultAuditLimit = 100
+
+// audit records a security relevant event of the request. The request
+// goes on if it can't be recorded, the failure is only logged
+func (cfg *ApiConfig) audit(r *http.Request, eventType string, actorId, targetId int, details map[string]string) {
+	if cfg.Audit == nil {
+		return
+	}
+
+	err := cfg.Audit.Record(audit.Event{
+		Type:      eventType,
+		ActorId:   actorId,
+		TargetId:  targetId,
+		IP:        handler.ClientIP(r),
+		UserAgent: r.UserAgent(),
+		Details:   details,
+	})
+	if err != nil {
+		log.Printf("couldn't record audit event %s: %v", eventType, err)
+	}
+}
+
+// auditSystem records an event nobody requested, like the purge of deleted accounts
+func (cfg *ApiConfig) auditSystem(eventType string, targetId int, details map[string]string) {
+	if cfg.Audit == nil {
+		return
+	}
+
+	err := cfg.Audit.Record(audit.Event{Type: eventType, TargetId: targetId, Details: details})
+	if err != nil {
+		log.Printf("couldn't record audit event %s: %v", eventType, err)
+	}
+}
+
+// AdminGetAuditHandler lists the audit events, the newest first. They can be
+// filtered by type, actor_id, target_id, ip and a since/until time range
+func (cfg *ApiConfig) AdminGetAuditHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := audit.Filter{
+		Type:  query.Get("type"),
+		IP:    query.Get("ip"),
+		Limit: defaultAuditLimit,
+	}
+
+	var err error
+	if value := query.Get("actor_id"); value != "" {
+		filter.ActorId, err = strconv.Atoi(value)
+		if err != nil {
+			handler.RespondWithError(w, http.StatusBadRequest, "invalid actor_id")
+			return
+		}
+	}
+	if value := query.Get("target_id"); value != "" {
+		filter.TargetId, err = strconv.Atoi(value)
+		if err != nil {
+			handler.RespondWithError(w, http.StatusBadRequest, "invalid target_id")
+			return
+		}
+	}
+	if value := query.Get("since"); value != "" {
+		filter.Since, err = time.Parse(time.RFC3339, value)
+		if err != nil {
+			handler.RespondWithError(w, http.StatusBadRequest, "since must be an RFC 3339 time")
+			return
+		}
+	}
+	if value := query.Get("until"); value != "" {
+		filter.Until, err = time.Parse(time.RFC3339, value)
+		if err != nil {
+			handler.RespondWithError(w, http.StatusBadRequest, "until must be an RFC 3339 time")
+			return
+		}
+	}
+	if value := query.Get("limit"); value != "" {
+		filter.Limit, err = strconv.Atoi(value)
+		if err != nil || filter.Limit < 1 {
+			handler.RespondWithError(w, http.StatusBadRequest, "limit must be a positive number")
+			return
+		}
+	}
+
+	events, err := cfg.Audit.Query(filter)
+	if err != nil {
+		handler.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	handler.RespondWithJSON(w, http.StatusOK, events)
+}
+
+// AdminVerifyAuditHandler checks that the hash chain of the audit log is intact
+func (cfg *ApiConfig) AdminVerifyAuditHandler(w http.ResponseWriter, r *http.Request) {
+	result, err := cfg.Audit.Verify()
+	if err != nil {
+		handler.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	handler.RespondWithJSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,244 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/database"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/handler"
+)
+
+type ReturnCollectionVals struct {
+	Id       int    `json:"id"`
+	Name     string `json:"name"`
+	ChirpIds []int  `json:"chirp_ids"`
+}
+
+func newReturnCollectionVals(collection database.Collection) ReturnCollectionVals {
+	return ReturnCollectionVals{
+		Id:       collection.ID,
+		Name:     collection.Name,
+		ChirpIds: collection.ChirpIds,
+	}
+}
+
+func (cfg *ApiConfig) GetBookmarksHandler(w http.ResponseWriter, r *http.Request) {
+	userId := principal(r).UserId
+
+	chirps, err := db.GetBookmarks(userId)
+	if err != nil {
+		handler.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	handler.RespondWithJSON(w, http.StatusOK, chirps)
+}
+
+func (cfg *ApiConfig) PostBookmarkHandler(w http.ResponseWriter, r *http.Request) {
+	userId := principal(r).UserId
+
+	type parameters struct {
+		ChirpId int `json:"chirp_id"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		handler.RespondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+
+	err = db.AddBookmark(userId, params.ChirpId)
+	if err != nil {
+		respondWithCollectionError(w, err)
+		return
+	}
+
+	handler.RespondWithJSON(w, http.StatusCreated, map[string]int{"chirp_id": params.ChirpId})
+}
+
+func (cfg *ApiConfig) DeleteBookmarkHandler(w http.ResponseWriter, r *http.Request) {
+	userId := principal(r).UserId
+
+	chirpId, err := strconv.Atoi(chi.URLParam(r, "chirpId"))
+	if err != nil {
+		handler.RespondWithError(w, http.StatusBadRequest, "invalid chirp id")
+		return
+	}
+
+	err = db.RemoveBookmark(userId, chirpId)
+	if err != nil {
+		respondWithCollectionError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *ApiConfig) GetCollectionsHandler(w http.ResponseWriter, r *http.Request) {
+	userId := principal(r).UserId
+
+	collections, err := db.GetCollections(userId)
+	if err != nil {
+		handler.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respBody := make([]ReturnCollectionVals, 0, len(collections))
+	for _, collection := range collections {
+		respBody = append(respBody, newReturnCollectionVals(collection))
+	}
+
+	handler.RespondWithJSON(w, http.StatusOK, respBody)
+}
+
+func (cfg *ApiConfig) PostCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	userId := principal(r).UserId
+
+	type parameters struct {
+		Name string `json:"name"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		handler.RespondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+
+	collection, err := db.CreateCollection(userId, params.Name)
+	if err != nil {
+		respondWithCollectionError(w, err)
+		return
+	}
+
+	handler.RespondWithJSON(w, http.StatusCreated, newReturnCollectionVals(collection))
+}
+
+func (cfg *ApiConfig) GetCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	userId := principal(r).UserId
+
+	collectionId, err := strconv.Atoi(chi.URLParam(r, "collectionId"))
+	if err != nil {
+		handler.RespondWithError(w, http.StatusBadRequest, "invalid collection id")
+		return
+	}
+
+	collection, err := db.GetCollection(userId, collectionId)
+	if err != nil {
+		respondWithCollectionError(w, err)
+		return
+	}
+
+	chirps, err := db.GetCollectionChirps(userId, collectionId)
+	if err != nil {
+		respondWithCollectionError(w, err)
+		return
+	}
+
+	type returnVals struct {
+		Id     int              `json:"id"`
+		Name   string           `json:"name"`
+		Chirps []database.Chirp `json:"chirps"`
+	}
+	respBody := returnVals{
+		Id:     collection.ID,
+		Name:   collection.Name,
+		Chirps: chirps,
+	}
+
+	handler.RespondWithJSON(w, http.StatusOK, respBody)
+}
+
+func (cfg *ApiConfig) DeleteCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	userId := principal(r).UserId
+
+	collectionId, err := strconv.Atoi(chi.URLParam(r, "collectionId"))
+	if err != nil {
+		handler.RespondWithError(w, http.StatusBadRequest, "invalid collection id")
+		return
+	}
+
+	err = db.DeleteCollection(userId, collectionId)
+	if err != nil {
+		respondWithCollectionError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *ApiConfig) PostCollectionChirpHandler(w http.ResponseWriter, r *http.Request) {
+	userId := principal(r).UserId
+
+	collectionId, err := strconv.Atoi(chi.URLParam(r, "collectionId"))
+	if err != nil {
+		handler.RespondWithError(w, http.StatusBadRequest, "invalid collection id")
+		return
+	}
+
+	type parameters struct {
+		ChirpId int `json:"chirp_id"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		handler.RespondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+
+	collection, err := db.AddChirpToCollection(userId, collectionId, params.ChirpId)
+	if err != nil {
+		respondWithCollectionError(w, err)
+		return
+	}
+
+	handler.RespondWithJSON(w, http.StatusOK, newReturnCollectionVals(collection))
+}
+
+func (cfg *ApiConfig) DeleteCollectionChirpHandler(w http.ResponseWriter, r *http.Request) {
+	userId := principal(r).UserId
+
+	collectionId, err := strconv.Atoi(chi.URLParam(r, "collectionId"))
+	if err != nil {
+		handler.RespondWithError(w, http.StatusBadRequest, "invalid collection id")
+		return
+	}
+
+	chirpId, err := strconv.Atoi(chi.URLParam(r, "chirpId"))
+	if err != nil {
+		handler.RespondWithError(w, http.StatusBadRequest, "invalid chirp id")
+		return
+	}
+
+	collection, err := db.RemoveChirpFromCollection(userId, collectionId, chirpId)
+	if err != nil {
+		respondWithCollectionError(w, err)
+		return
+	}
+
+	handler.RespondWithJSON(w, http.StatusOK, newReturnCollectionVals(collection))
+}
+
+// respondWithCollectionError maps bookmark and collection errors to status codes
+func respondWithCollectionError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, database.ErrChirpNotFound),
+		errors.Is(err, database.ErrBookmarkNotFound),
+		errors.Is(err, database.ErrCollectionNotFound):
+		handler.RespondWithError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, database.ErrBlocked):
+		handler.RespondWithError(w, http.StatusForbidden, err.Error())
+	case errors.Is(err, database.ErrCollectionExists):
+		handler.RespondWithError(w, http.StatusConflict, err.Error())
+	default:
+		handler.RespondWithError(w, http.StatusBadRequest, err.Error())
+	}
+}
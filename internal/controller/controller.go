@@ -1,6 +1,9 @@
 package controller
 
 import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"log"
@@ -8,23 +11,52 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"text/template"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/golang-jwt/jwt/v5"
-	"github.com/mustafa-mun/chirpy-bootdev/internal/bcrypt"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/audit"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/auth"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/csrf"
 	"github.com/mustafa-mun/chirpy-bootdev/internal/database"
 	"github.com/mustafa-mun/chirpy-bootdev/internal/handler"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/lockout"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/mailer"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/ratelimit"
 )
 
 // Create new database
 var db *database.DB
 
+// ReturnUserVals is the private view of a user, only returned to the user itself
 type ReturnUserVals struct {
 	Id int `json:"id"`
 	Email string `json:"email"`
+	EmailVerified bool `json:"email_verified"`
 	IsChirpyRed bool `json:"is_chirpy_red"`
+	Handle string `json:"handle"`
+	DisplayName string `json:"display_name"`
+	Bio string `json:"bio"`
+	AvatarURL string `json:"avatar_url"`
+	Role database.Role `json:"role"`
+	TwoFactorEnabled bool `json:"two_factor_enabled"`
+}
+
+func newReturnUserVals(user database.User) ReturnUserVals {
+	return ReturnUserVals{
+		Id: user.ID,
+		Email: user.Email,
+		EmailVerified: user.EmailVerified,
+		IsChirpyRed: user.IsChirpyRed,
+		Handle: user.Handle,
+		DisplayName: user.DisplayName,
+		Bio: user.Bio,
+		AvatarURL: user.AvatarURL,
+		Role: user.GetRole(),
+		TwoFactorEnabled: user.HasTwoFactor(),
+	}
 }
 
 func InitDB() {
@@ -38,7 +70,40 @@ func InitDB() {
 
 type ApiConfig struct {
 	FileserverHits int
-	JwtSecret string
+	// Signs the download urls of exports, it must never be a JWT key
+	ExportSigningKey []byte
+	// Issues and validates the JWTs
+	Auth *auth.Authenticator
+	// What happens to the chirps of a deleted account once it is purged
+	ChirpRetention database.ChirpRetention
+	// How long a deleted account can be reactivated by logging in
+	DeletionGracePeriod time.Duration
+	// Directory the personal data exports are written to
+	ExportsDir string
+	// How long a signed export download url stays valid
+	ExportURLTTL time.Duration
+	// Number of expired revoked tokens dropped by the sweeper
+	RevokedTokensSwept int64
+	// Delivers the mails sent to users
+	Mailer mailer.Mailer
+	// Base url of the site, used for the links in mails
+	PublicURL string
+	// What users can't do until they verify their email
+	UnverifiedRestrictions []Restriction
+	// How failed logins of an account slow down and lock out further logins
+	LoginPolicy lockout.Policy
+	// Failed logins of each client IP
+	LoginIPAttempts *lockout.Tracker
+	// Keeps the buckets of the rate limits
+	RateLimits ratelimit.Store
+	// Append-only log of security relevant events
+	Audit *audit.Log
+	// Issues and checks the CSRF tokens of browser forms and cookie sessions
+	CSRF csrf.Protection
+	// Lets browser clients keep their session tokens in HttpOnly cookies
+	CookieAuth bool
+	// Cookies are only sent over https
+	SecureCookies bool
 }
 
 func (cfg *ApiConfig) HealthzHandler(w http.ResponseWriter, r *http.Request) {
@@ -47,6 +112,12 @@ func (cfg *ApiConfig) HealthzHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("OK"))
 }
 
+// JWKSHandler publishes the public keys access tokens can be verified with
+func (cfg *ApiConfig) JWKSHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	handler.RespondWithJSON(w, http.StatusOK, cfg.Auth.Keyring.JWKS())
+}
+
 func (cfg *ApiConfig) MiddlewareMetricsInc(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
@@ -60,6 +131,8 @@ func (cfg *ApiConfig) MiddlewareMetricsInc(next http.Handler) http.Handler {
 
 type Context struct {
 	Hits int
+	RevokedTokens int
+	RevokedTokensSwept int64
 }
 
 func (cfg *ApiConfig) MetricsHandler(w http.ResponseWriter, r *http.Request) {
@@ -69,18 +142,27 @@ func (cfg *ApiConfig) MetricsHandler(w http.ResponseWriter, r *http.Request) {
 	<body>
 			<h1>Welcome, Chirpy Admin</h1>
 			<p>Chirpy has been visited {{.Hits}} times!</p>
+			<p>Revoked token store size: {{.RevokedTokens}}</p>
+			<p>Expired revoked tokens swept: {{.RevokedTokensSwept}}</p>
 	</body>
 	
 	</html>
 	`
 
-	w.Header().Add("Content Type", "text/html")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	// The template name "template" does not matter here
 	templates := template.New("template")
 	// "doc" is the constant that holds the HTML content
 	templates.New("doc").Parse(doc)
+	revokedTokens, err := db.RevokedTokenCount()
+	if err != nil {
+		handler.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
 	context := Context{
 		Hits: cfg.FileserverHits,
+		RevokedTokens: revokedTokens,
+		RevokedTokensSwept: atomic.LoadInt64(&cfg.RevokedTokensSwept),
 	}
   templates.Lookup("doc").Execute(w, context)
 }
@@ -91,9 +173,11 @@ func (cfg *ApiConfig) GetChirpsHandler(w http.ResponseWriter, r *http.Request) {
 	authorIdParam := r.URL.Query().Get("author_id")
 	sortParam := r.URL.Query().Get("sort")
 	
+	// Logged in users don't see chirps of users they blocked or muted
+	viewerId := principal(r).UserId
 
 	// Get all chirps
-	chirps, err := db.GetChirps(authorIdParam, sortParam)
+	chirps, err := db.GetChirps(authorIdParam, sortParam, viewerId)
 	if err != nil {
 		handler.RespondWithError(w, http.StatusBadRequest, err.Error())
 		return
@@ -106,24 +190,22 @@ func (cfg *ApiConfig) GetSingleChirpHandler(w http.ResponseWriter, r *http.Reque
 	// get chirpId from url parameter
 	id := chi.URLParam(r, "chirpId")
 	
-	// Read database file
-	structure, err := db.LoadDB()
+	intId, err := strconv.Atoi(id)
 	if err != nil {
 		handler.RespondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	intId, err := strconv.Atoi(id)
+	// chirps of deleted accounts and blocked users are hidden
+	chirp, err := db.GetChirp(intId, principal(r).UserId)
+	if errors.Is(err, database.ErrChirpNotFound) {
+		handler.RespondWithError(w, http.StatusNotFound, "not found")
+		return
+	}
 	if err != nil {
 		handler.RespondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
-
-	chirp, ok := structure.Chirps[intId]
-  // chirp not found
-	if !ok {
-		handler.RespondWithError(w, http.StatusNotFound, "not found")
-	} 
   // chirp found
 	handler.RespondWithJSON(w, http.StatusOK, chirp)
 
@@ -132,9 +214,8 @@ func (cfg *ApiConfig) GetSingleChirpHandler(w http.ResponseWriter, r *http.Reque
 func (cfg *ApiConfig) PostChirpHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Check auth
-	tokenObj, er := cfg.CheckJwtToken(w, r)
-	if er != nil {
-		handler.RespondWithError(w, http.StatusUnauthorized, er.Error())
+	intId := principal(r).UserId
+	if !cfg.checkVerified(w, intId, RestrictPostChirps) {
 		return
 	}
 	// decode the json request body
@@ -176,13 +257,6 @@ func (cfg *ApiConfig) PostChirpHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get author id from JWT token
-	authorId := tokenObj.Claims.(jwt.MapClaims)["sub"].(string)
-	intId, err := strconv.Atoi(authorId)
-	if err != nil {
-		handler.RespondWithError(w, http.StatusBadRequest, err.Error())
-		return
-	}
 	// Create and save the new chirp
 	newChirp, err := db.CreateChirp(reqBody, intId)
 
@@ -203,12 +277,7 @@ func (cfg *ApiConfig) PostChirpHandler(w http.ResponseWriter, r *http.Request) {
 func (cfg *ApiConfig) DeleteChirpHandler(w http.ResponseWriter, r *http.Request) {
 	// Check auth
 	
-	tokenObj, err := cfg.CheckJwtToken(w, r)
-
-	if err != nil {
-		handler.RespondWithError(w, http.StatusUnauthorized, err.Error())
-		return
-	}
+	intAuthorId := principal(r).UserId
 
 	// take id from url parameter
 	id := chi.URLParam(r, "chirpID")
@@ -219,14 +288,6 @@ func (cfg *ApiConfig) DeleteChirpHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	authorId := tokenObj.Claims.(jwt.MapClaims)["sub"].(string)
-	intAuthorId, err := strconv.Atoi(authorId)
-
-	if err != nil {
-		handler.RespondWithError(w, http.StatusInternalServerError, err.Error())
-		return
-	}
-
 	err = db.DeleteChirp(intId, intAuthorId)
 
 	if err != nil {
@@ -251,6 +312,10 @@ func (cfg *ApiConfig) PostUserHandler(w http.ResponseWriter, r *http.Request) {
 		// the struct fields must be exported (start with a capital letter) if you want them parsed
 		Password string `json:"password"`
 		Email string `json:"email"`
+		Handle string `json:"handle"`
+		DisplayName string `json:"display_name"`
+		Bio string `json:"bio"`
+		AvatarURL string `json:"avatar_url"`
 	}
 
 	decoder := json.NewDecoder(r.Body)
@@ -263,9 +328,15 @@ func (cfg *ApiConfig) PostUserHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Create new User with database package
+	profile := database.Profile{
+		Handle: params.Handle,
+		DisplayName: params.DisplayName,
+		Bio: params.Bio,
+		AvatarURL: params.AvatarURL,
+	}
 
 	// Create and save the new user
-	newUser, err := db.CreateUser(params.Password, params.Email)
+	newUser, err := db.CreateUser(params.Password, params.Email, profile)
 
 	if err != nil {
 		handler.RespondWithError(w, http.StatusBadRequest, err.Error())
@@ -274,12 +345,10 @@ func (cfg *ApiConfig) PostUserHandler(w http.ResponseWriter, r *http.Request) {
 
 	
 	// Return new user as a json
-	respBody := ReturnUserVals{
-			Id: newUser.ID,
-			Email: newUser.Email,
-			IsChirpyRed: newUser.IsChirpyRed,
-	}
-	handler.RespondWithJSON(w, http.StatusCreated, respBody)
+	cfg.audit(r, audit.UserCreated, newUser.ID, newUser.ID, nil)
+	cfg.sendEmailVerification(newUser)
+
+	handler.RespondWithJSON(w, http.StatusCreated, newReturnUserVals(newUser))
 }
 
 
@@ -290,6 +359,8 @@ func (cfg *ApiConfig) LoginHandler(w http.ResponseWriter, r *http.Request) {
 		// the struct fields must be exported (start with a capital letter) if you want them parsed
 		Password string `json:"password"`
 		Email string `json:"email"`
+		// Browser clients can ask for the tokens in HttpOnly cookies
+		UseCookies bool `json:"use_cookies"`
 	}
 
 	decoder := json.NewDecoder(r.Body)
@@ -300,302 +371,466 @@ func (cfg *ApiConfig) LoginHandler(w http.ResponseWriter, r *http.Request) {
 		handler.RespondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters")
 		return
 	}
+	if params.UseCookies && !cfg.CookieAuth {
+		handler.RespondWithError(w, http.StatusBadRequest, errCookiesDisabled.Error())
+		return
+	}
 
 
-	// Find the user by email 
-	usr := (*database.User)(nil)
-
-	// Read database file
-	structure, err := db.LoadDB()
+	// Every failure looks the same, whether or not the email has an account
+	usr, err := cfg.authenticatePassword(r, params.Email, params.Password)
 	if err != nil {
-		handler.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		respondWithLoginError(w, err)
 		return
 	}
 
-	users := structure.Users
+	// Users with a second factor get a challenge to answer first
+	if usr.HasTwoFactor() {
+		mfaToken, err := cfg.createMFAToken(usr.ID)
+		if err != nil {
+			handler.RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		type returnVals struct {
+			MFARequired bool `json:"mfa_required"`
+			MFAToken string `json:"mfa_token"`
+		}
+		handler.RespondWithJSON(w, http.StatusOK, returnVals{MFARequired: true, MFAToken: mfaToken})
+		return
+	}
 
-	for _, user := range users {
-		// If user is found, set usr variable to user
-		if user.Email == params.Email {
-			usr = &user
+	cfg.completeLogin(w, r, usr, params.UseCookies)
+}
+
+// completeLogin starts a new session of the authenticated user and responds
+// with its tokens, or sets them as cookies if the client asked for that
+func (cfg *ApiConfig) completeLogin(w http.ResponseWriter, r *http.Request, user database.User, useCookies bool) {
+	usr := &user
+
+	// Logging in within the grace period reactivates a deleted account
+	if usr.IsDeleted() {
+		reactivated, err := db.ReactivateUser(usr.ID, cfg.DeletionGracePeriod, time.Now())
+		if errors.Is(err, database.ErrGracePeriodOver) {
+			respondWithLoginError(w, errWrongCredentials)
+			return
+		}
+		if err != nil {
+			handler.RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
 		}
+		usr = &reactivated
+		cfg.audit(r, audit.UserReactivated, usr.ID, usr.ID, nil)
 	}
 
-	// If usr is nil, user is not found
-	if usr == nil {
-		handler.RespondWithError(w, http.StatusNotFound, "user not found")
+	err := loginSucceeded(*usr)
+	if err != nil {
+		handler.RespondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	// User is found, check the password 
-	err = bcrypt.CompareHashPassword(usr.Password, params.Password)
+	// Create access and refresh jwt tokens
+	// Every login starts a new session, its refresh tokens form a family
+	refreshToken, refreshRecord, err := cfg.createRefreshToken(usr.ID, "")
 
 	if err != nil {
-		// Password is wrong
-		handler.RespondWithError(w, http.StatusUnauthorized, "passwords do not match")
+		handler.RespondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	// Password is true, create access and refresh jwt tokens
-	accessToken, err := cfg.createToken("chirpy-access", strconv.Itoa(usr.ID), 3600)
+	now := time.Now().UTC()
+	session := database.Session{
+		ID: newTokenId(),
+		UserId: usr.ID,
+		UserAgent: r.UserAgent(),
+		IP: handler.ClientIP(r),
+		CreatedAt: now,
+		LastUsedAt: now,
+		ExpiresAt: refreshRecord.ExpiresAt,
+	}
+	err = db.CreateSession(session, refreshRecord)
 
 	if err != nil {
 		handler.RespondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-
-	// Sign the token with secret key
-	refreshToken, err := cfg.createToken("chirpy-refresh", strconv.Itoa(usr.ID), 5184000)
+	accessToken, err := cfg.createAccessToken(usr.ID, session)
 
 	if err != nil {
 		handler.RespondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
+	cfg.audit(r, audit.LoginSucceeded, usr.ID, usr.ID, map[string]string{
+		"session_id": session.ID,
+		"two_factor": strconv.FormatBool(usr.HasTwoFactor()),
+	})
 
 
 	// Return logged user with JWT token
 	type returnVals struct {
-		Id int `json:"id"`
-		Email string `json:"email"`
-		IsChirpyRed bool `json:"is_chirpy_red"`
-		Token string `json:"token"`
-		RefreshToken string `json:"refresh_token"`
+		ReturnUserVals
+		Token string `json:"token,omitempty"`
+		RefreshToken string `json:"refresh_token,omitempty"`
+		CSRFToken string `json:"csrf_token,omitempty"`
 	}
 	respBody := returnVals{
-			Id: usr.ID,
-			Email: usr.Email,
-			IsChirpyRed: usr.IsChirpyRed,
+			ReturnUserVals: newReturnUserVals(*usr),
 			Token: accessToken,
 			RefreshToken: refreshToken,
 	}
+	if useCookies {
+		respBody.CSRFToken, err = cfg.setSessionCookies(w, accessToken, refreshToken, refreshRecord.ExpiresAt)
+		if err != nil {
+			handler.RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		// Scripts never see the tokens of a cookie session
+		respBody.Token, respBody.RefreshToken = "", ""
+	}
 
 	handler.RespondWithJSON(w, http.StatusOK, respBody)
 }
 
 
 func (cfg *ApiConfig) UpdateUserHandler(w http.ResponseWriter, r *http.Request) {
-	authHeader := r.Header.Get("Authorization")
-	token := strings.Split(authHeader, " ")[1]
-
-	tokenObj, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
-		// Provide the key or validation logic for verifying the token
-		// For example, if you're using HMAC:
-		return []byte(cfg.JwtSecret), nil
-	})
-	if err != nil {
-		handler.RespondWithError(w, http.StatusUnauthorized, err.Error())
-		return
-	}
-
-	if !tokenObj.Valid {
-		handler.RespondWithError(w, http.StatusUnauthorized, "Invalid token")
-		return
-	}
-
-
-	// Check if token is a refresh token 
-	if tokenObj.Claims.(jwt.MapClaims)["iss"].(string) == "chirpy-refresh" {
-		handler.RespondWithError(w, http.StatusUnauthorized, "token is a refresh token")
-		return
-	}
-
-	// Token is valid, get the user id on jwtClaims
-
-	userId := tokenObj.Claims.(jwt.MapClaims)["sub"].(string)
+	// Check auth
+	userId := principal(r).UserId
 
 	type parameters struct {
 		// these tags indicate how the keys in the JSON should be mapped to the struct fields
 		// the struct fields must be exported (start with a capital letter) if you want them parsed
 		Password string `json:"password"`
 		Email string `json:"email"`
+		CurrentPassword string `json:"current_password"`
 	}
 
 	decoder := json.NewDecoder(r.Body)
 	params := parameters{}
-	decoder.Decode(&params)
-
-	// Handle user updating
-
-	intId, err := strconv.Atoi(userId)
+	err := decoder.Decode(&params)
 	if err != nil {
-		handler.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		handler.RespondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+	// Same as PATCH /users/me, which this route predates
+	if (params.Email != "" || params.Password != "") && !checkPasswordForChange(w, userId, params.CurrentPassword) {
 		return
 	}
 
-	updatedUser, err := db.UpdateUser(params.Email, params.Password, intId)
+	// Handle user updating, omitted fields are kept as they are
+	patch := database.UserPatch{}
+	if params.Email != "" {
+		patch.Email = &params.Email
+	}
+	if params.Password != "" {
+		patch.Password = &params.Password
+	}
+
+	updatedUser, err := db.PatchUser(userId, patch)
 	if err != nil {
-		handler.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		respondWithUserError(w, err)
 		return
 	}
-	// return updated user
-
-	respBody := ReturnUserVals{
-			Id: updatedUser.ID,
-			Email: updatedUser.Email,
-			IsChirpyRed: updatedUser.IsChirpyRed,
+	cfg.auditUserPatch(r, updatedUser, patch)
+	if patch.Email != nil && !updatedUser.EmailVerified {
+		cfg.sendEmailVerification(updatedUser)
 	}
+	// return updated user
 
-	handler.RespondWithJSON(w, http.StatusOK, respBody)
+	handler.RespondWithJSON(w, http.StatusOK, newReturnUserVals(updatedUser))
 
 }
 
 
 func (cfg *ApiConfig) RefreshTokenHandler(w http.ResponseWriter, r *http.Request) {
 	
-
-	structure, err := db.LoadDB()
-
+	// Cookie sessions send the refresh token in their cookie
+	token, fromCookie, err := cfg.requestToken(r, cfg.refreshCookieName())
 	if err != nil {
-		handler.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		handler.RespondWithError(w, tokenErrorStatus(err), err.Error())
 		return
 	}
-
-	tokenObj, err := cfg.CheckJwtToken(w, r)
+	// Revoked tokens are rejected by verifyToken
+	claims, err := cfg.verifyToken(token, auth.TokenTypeRefresh)
 	if err != nil {
-		handler.RespondWithError(w, http.StatusUnauthorized, "Invalid token")
+		handler.RespondWithError(w, http.StatusUnauthorized, err.Error())
 		return
 	}
 
-
-	if tokenObj.Claims.(jwt.MapClaims)["iss"].(string) != "chirpy-refresh" {
-		handler.RespondWithError(w, http.StatusUnauthorized, "token is not a refresh token")
+	// Token is valid, rotate it into a new refresh token
+	accessToken, refreshToken, session, err := cfg.rotateTokens(claims)
+	if errors.Is(err, database.ErrTokenReused) {
+		// The token was stolen, every token of its family is revoked now
+		handler.RespondWithError(w, http.StatusUnauthorized, "refresh token reuse detected, please log in again")
 		return
 	}
-
-	// Check if token is revoked
-	revokedTokens := structure.RevokedTokens
-	_, ok := revokedTokens[tokenObj.Raw]
-	if ok {
+	if errors.Is(err, database.ErrTokenNotFound) || errors.Is(err, database.ErrTokenRevoked) || errors.Is(err, database.ErrSessionNotFound) {
 		handler.RespondWithError(w, http.StatusUnauthorized, "Revoked token")
 		return
 	}
-
-	// Token is valid create new access token
-	userId := tokenObj.Claims.(jwt.MapClaims)["sub"].(string)
-
-	accessToken, err := cfg.createToken("chirpy-access", userId, 3600)
-
 	if err != nil {
 		handler.RespondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	// Return new token
+	// Return new tokens, the presented refresh token can't be used anymore
 	type returnVals struct {
-		Token string `json:"token"`
+		Token string `json:"token,omitempty"`
+		RefreshToken string `json:"refresh_token,omitempty"`
+		CSRFToken string `json:"csrf_token,omitempty"`
 	}
 	respBody := returnVals{
 			Token: accessToken ,
+			RefreshToken: refreshToken,
+	}
+	if fromCookie {
+		respBody = returnVals{}
+		respBody.CSRFToken, err = cfg.setSessionCookies(w, accessToken, refreshToken, session.ExpiresAt)
+		if err != nil {
+			handler.RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
 	}
 	
 	handler.RespondWithJSON(w, http.StatusOK, respBody)
 }
 
+// rotateTokens rotates a validated refresh token and returns a new access
+// and refresh token together with the session they belong to
+func (cfg *ApiConfig) rotateTokens(claims *auth.Claims) (string, string, database.Session, error) {
+	userId, err := claims.UserId()
+	if err != nil {
+		return "", "", database.Session{}, err
+	}
+
+	refreshToken, refreshRecord, err := cfg.createRefreshToken(userId, claims.ClientId)
+	if err != nil {
+		return "", "", database.Session{}, err
+	}
+
+	refreshRecord, err = db.RotateRefreshToken(claims.ID, refreshRecord, time.Now())
+	if err != nil {
+		return "", "", database.Session{}, err
+	}
+
+	// Tokens of a session granted to an OAuth client keep its scopes
+	session, err := db.GetSession(refreshRecord.FamilyId)
+	if err != nil {
+		return "", "", database.Session{}, err
+	}
+
+	accessToken, err := cfg.createAccessToken(userId, session)
+	if err != nil {
+		return "", "", database.Session{}, err
+	}
+
+	return accessToken, refreshToken, session, nil
+}
+
 
 func (cfg *ApiConfig) RevokeTokenHandler(w http.ResponseWriter, r *http.Request) {
 	
-	structure, err := db.LoadDB()
-
+	// Cookie sessions send the refresh token in their cookie
+	rawToken, fromCookie, err := cfg.requestToken(r, cfg.refreshCookieName())
 	if err != nil {
-		handler.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		handler.RespondWithError(w, tokenErrorStatus(err), err.Error())
 		return
 	}
-
-	tokenObj, err := cfg.CheckJwtToken(w, r)
+	// Revoked tokens are rejected by verifyToken
+	claims, err := cfg.verifyToken(rawToken, auth.TokenTypeRefresh)
 	if err != nil {
-		handler.RespondWithError(w, http.StatusUnauthorized, "Invalid token")
+		handler.RespondWithError(w, http.StatusUnauthorized, err.Error())
 		return
 	}
+	tokenId := claims.ID
 
-
-	if tokenObj.Claims.(jwt.MapClaims)["iss"].(string) != "chirpy-refresh" {
-		handler.RespondWithError(w, http.StatusUnauthorized, "token is not a refresh token")
+	// Revoke the token until it expires
+	err = db.RevokeToken(tokenId, claims.ExpiresAt.Time)
+	if err != nil {
+		handler.RespondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
-	
-	// Check if token is revoked
-	revokedTokens := structure.RevokedTokens
-	_, ok := revokedTokens[tokenObj.Raw]
-	if ok {
-		handler.RespondWithError(w, http.StatusUnauthorized, "Revoked token")
+
+	// Revoke the family of the token together with it
+	err = db.RevokeRefreshToken(tokenId, time.Now())
+	if err != nil && !errors.Is(err, database.ErrTokenRevoked) && !errors.Is(err, database.ErrTokenNotFound) {
+		handler.RespondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
+	userId, _ := claims.UserId()
+	cfg.audit(r, audit.TokenRevoked, userId, userId, map[string]string{"token_id": tokenId})
 
-	// Revoke the token
-	revokedTokens[tokenObj.Raw] = tokenObj.Raw
-	structure.RevokedTokens = revokedTokens
-	// Write the updated data to the database file
-	db.WriteDB(structure)
+	// Cookie sessions are logged out of the browser
+	if fromCookie {
+		cfg.clearSessionCookies(w)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
 
 	// return the revoked token 
-	// Return new token
 	type returnVals struct {
 		RevokedToken string `json:"revoked_token"`
 	}
 	respBody := returnVals{
-			RevokedToken: tokenObj.Raw,
+			RevokedToken: rawToken,
 	}
 	handler.RespondWithJSON(w, http.StatusOK, respBody)
 }
 
-func (cfg *ApiConfig) createToken(issuer, subject string, expireDate int) (string, error){
+// accessTokenTTL is how long an access token is valid
+const accessTokenTTL = time.Hour
+
+// refreshTokenTTL is how long a refresh token is valid
+const refreshTokenTTL = 60 * 24 * time.Hour
+
+// createAccessToken signs a new access token for the session of the user.
+// Sessions granted to OAuth clients get tokens limited to the granted scopes
+func (cfg *ApiConfig) createAccessToken(userId int, session database.Session) (string, error) {
+	return cfg.Auth.Sign(auth.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject: strconv.Itoa(userId),
+			ID: newTokenId(),
+		},
+		Type: auth.TokenTypeAccess,
+		SessionId: session.ID,
+		ClientId: session.ClientId,
+		Scope: strings.Join(session.Scopes, " "),
+	}, accessTokenTTL)
+}
 
-	newAccessToken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
-		Issuer: issuer,
-		IssuedAt: jwt.NewNumericDate(time.Now().UTC()),
-		ExpiresAt: &jwt.NumericDate{Time: time.Now().Add(time.Second * time.Duration(expireDate))},
-		Subject: subject,
-	})
 
-	// Sign the token with secret key
-	accessToken, err := newAccessToken.SignedString([]byte(cfg.JwtSecret))
+// SweepExpiredTokens drops revoked tokens that expired in the meantime
+func (cfg *ApiConfig) SweepExpiredTokens() {
+	cfg.LoginIPAttempts.Sweep(time.Now())
+	swept, err := db.SweepExpiredTokens(time.Now())
+	if err != nil {
+		log.Printf("couldn't sweep expired tokens: %v", err)
+		return
+	}
+	atomic.AddInt64(&cfg.RevokedTokensSwept, int64(swept))
+}
 
+// createRefreshToken signs a new refresh token for the user and returns it
+// with its server side record. The caller decides the family of the record.
+// Refresh tokens of sessions granted to an OAuth client carry the client id
+func (cfg *ApiConfig) createRefreshToken(userId int, clientId string) (string, database.RefreshToken, error) {
+	now := time.Now().UTC()
+	record := database.RefreshToken{
+		ID: newTokenId(),
+		UserId: userId,
+		IssuedAt: now,
+		ExpiresAt: now.Add(refreshTokenTTL),
+	}
+
+	refreshToken, err := cfg.Auth.Sign(auth.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject: strconv.Itoa(userId),
+			ID: record.ID,
+		},
+		Type: auth.TokenTypeRefresh,
+		ClientId: clientId,
+	}, refreshTokenTTL)
 	if err != nil {
-		return "", err
+		return "", database.RefreshToken{}, err
 	}
 
-	return accessToken, nil
+	return refreshToken, record, nil
 }
 
+// newTokenId returns a random id for the jti claim of a token
+func newTokenId() string {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
 
-func (cfg *ApiConfig) CheckJwtToken(w http.ResponseWriter, r *http.Request) (*jwt.Token, error){
-	authHeader := r.Header.Get("Authorization")
-	if authHeader == "" {
-    // Handle the case when Authorization header is missing or empty
-		return nil, errors.New("jwt token missing")
+// verifyToken validates a raw JWT, which must be of the type. Revoked tokens,
+// tokens of deleted users and tokens of ended sessions are rejected
+func (cfg *ApiConfig) verifyToken(token string, typ auth.TokenType) (*auth.Claims, error) {
+	claims, err := cfg.Auth.Parse(token, typ)
+	if err != nil {
+		return nil, err
 	}
-	token := strings.Split(authHeader, " ")[1]
 
-	tokenObj, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
-		// Provide the key or validation logic for verifying the token
-		// For example, if you're using HMAC:
-		return []byte(cfg.JwtSecret), nil
-	})
+	// Check if token is revoked
+	revoked, err := db.IsTokenRevoked(claims.ID)
 	if err != nil {
 		return nil, err
 	}
+	if revoked {
+		return nil, errors.New("token has been revoked")
+	}
 
-	if !tokenObj.Valid {
-		handler.RespondWithError(w, http.StatusUnauthorized, "Invalid token")
+	// Tokens of deleted accounts and tokens issued before
+	// all sessions of the user were revoked are not valid anymore
+	err = checkTokenUser(claims)
+	if err != nil {
 		return nil, err
 	}
 
-	return tokenObj, nil
+	return claims, nil
+}
+
+func checkTokenUser(claims *auth.Claims) error {
+	userId, err := claims.UserId()
+	if err != nil {
+		return err
+	}
+
+	user, err := db.GetUser(userId)
+	if err != nil {
+		return errors.New("token has been revoked")
+	}
+	if user.IsSuspended() {
+		return database.ErrUserSuspended
+	}
+
+	if claims.IssuedAt.Unix() < user.TokensValidAfter {
+		return errors.New("token has been revoked")
+	}
+
+	// Access tokens die together with the session they were issued for
+	if claims.SessionId != "" {
+		session, err := db.GetSession(claims.SessionId)
+		if err != nil || session.UserId != userId || !session.IsActive(time.Now()) {
+			return errors.New("token has been revoked")
+		}
+	}
+
+	return nil
+}
+
+// authenticate checks the access token or personal access token
+// of the request and returns the principal it was issued to
+func (cfg *ApiConfig) authenticate(r *http.Request) (auth.Principal, error) {
+	token, _, err := cfg.requestToken(r, cfg.accessCookieName())
+	if err != nil {
+		return auth.Principal{}, err
+	}
+	if auth.IsPersonalToken(token) {
+		return authenticatePersonalToken(token)
+	}
+
+	claims, err := cfg.verifyToken(token, auth.TokenTypeAccess)
+	if err != nil {
+		return auth.Principal{}, err
+	}
+
+	return claims.Principal()
 }
 
 
 func(cfg *ApiConfig) PolkaWebhooksHandler(w http.ResponseWriter, r *http.Request) {
 
-	authHeader := r.Header.Get("Authorization")
-	if authHeader == "" {
+	apiKey, err := auth.HeaderCredentials(r.Header, "ApiKey")
+	if errors.Is(err, auth.ErrMissingToken) {
     // Handle the case when Authorization header is missing or empty
 		handler.RespondWithError(w, http.StatusUnauthorized, "missing api key")
 		return
 	}
-	apiKey := strings.Split(authHeader, " ")[1]
-
-	if apiKey != os.Getenv("POLKA_KEY") {
+	if err != nil || subtle.ConstantTimeCompare([]byte(apiKey), []byte(os.Getenv("POLKA_KEY"))) != 1 {
 		handler.RespondWithError(w, http.StatusUnauthorized, "invalid api key")
 		return
 	}
@@ -608,7 +843,7 @@ func(cfg *ApiConfig) PolkaWebhooksHandler(w http.ResponseWriter, r *http.Request
 
 	decoder := json.NewDecoder(r.Body)
 	params := parameters{}
-	err := decoder.Decode(&params)
+	err = decoder.Decode(&params)
 	if err != nil {
 		// handle decode parameters error 
 		handler.RespondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters")
@@ -651,6 +886,7 @@ func(cfg *ApiConfig) PolkaWebhooksHandler(w http.ResponseWriter, r *http.Request
 	structure.Users = users
 
 	db.WriteDB(structure)
+	cfg.audit(r, audit.UserUpgraded, 0, user.ID, map[string]string{"source": "polka"})
 
 	// Send ok status with empty json body
 	handler.RespondWithJSON(w, http.StatusOK, make(map[string]interface{}))
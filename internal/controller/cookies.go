@@ -0,0 +1,115 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/mustafa-mun/chirpy-bootdev/internal/auth"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/csrf"
+)
+
+// Browser clients can keep their tokens in HttpOnly cookies instead of
+// storage scripts can read. The browser sends the cookies on its own, so
+// unsafe requests authenticated by them need the CSRF token too
+
+var errCookiesDisabled = errors.New("cookie sessions are disabled")
+
+// accessCookieName is the cookie the access token is kept in. Secure cookies
+// get the __Host- prefix so subdomains can't overwrite them
+func (cfg *ApiConfig) accessCookieName() string {
+	if cfg.SecureCookies {
+		return "__Host-access_token"
+	}
+	return "access_token"
+}
+
+// refreshCookieName is the cookie the refresh token is kept in. It is only
+// sent to the api, which is where it is refreshed and revoked
+func (cfg *ApiConfig) refreshCookieName() string {
+	if cfg.SecureCookies {
+		return "__Secure-refresh_token"
+	}
+	return "refresh_token"
+}
+
+const refreshCookiePath = "/api"
+
+// setSessionCookies stores the tokens of a session in the browser
+// and returns the CSRF token the client has to send with them. The CSRF
+// token is always a new one, a token the browser had before the login
+// could have been set by another site
+func (cfg *ApiConfig) setSessionCookies(w http.ResponseWriter, accessToken, refreshToken string, refreshExpiresAt time.Time) (string, error) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     cfg.accessCookieName(),
+		Value:    accessToken,
+		Path:     "/",
+		MaxAge:   int(accessTokenTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   cfg.SecureCookies,
+		SameSite: http.SameSiteStrictMode,
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     cfg.refreshCookieName(),
+		Value:    refreshToken,
+		Path:     refreshCookiePath,
+		MaxAge:   int(time.Until(refreshExpiresAt).Seconds()),
+		HttpOnly: true,
+		Secure:   cfg.SecureCookies,
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	return cfg.CSRF.NewToken(w)
+}
+
+// clearSessionCookies removes the tokens of a session from the browser
+func (cfg *ApiConfig) clearSessionCookies(w http.ResponseWriter) {
+	for _, cookie := range []struct{ name, path string }{
+		{cfg.accessCookieName(), "/"},
+		{cfg.refreshCookieName(), refreshCookiePath},
+	} {
+		http.SetCookie(w, &http.Cookie{
+			Name:     cookie.name,
+			Path:     cookie.path,
+			MaxAge:   -1,
+			HttpOnly: true,
+			Secure:   cfg.SecureCookies,
+			SameSite: http.SameSiteStrictMode,
+		})
+	}
+}
+
+// hasCookie reports whether the browser sent the cookie of a cookie session
+func (cfg *ApiConfig) hasCookie(r *http.Request, name string) bool {
+	if !cfg.CookieAuth {
+		return false
+	}
+	cookie, err := r.Cookie(name)
+	return err == nil && cookie.Value != ""
+}
+
+// requestToken returns the token of the Authorization header, or the token
+// of the cookie for requests without one. fromCookie tells which it was
+func (cfg *ApiConfig) requestToken(r *http.Request, cookieName string) (token string, fromCookie bool, err error) {
+	token, err = auth.BearerToken(r.Header)
+	if !errors.Is(err, auth.ErrMissingToken) || !cfg.hasCookie(r, cookieName) {
+		return token, false, err
+	}
+
+	if !csrf.IsSafeMethod(r.Method) {
+		err = cfg.CSRF.Check(r)
+		if err != nil {
+			return "", true, err
+		}
+	}
+	cookie, _ := r.Cookie(cookieName)
+	return cookie.Value, true, nil
+}
+
+// tokenErrorStatus is the status of a request whose token was rejected
+func tokenErrorStatus(err error) int {
+	if errors.Is(err, csrf.ErrInvalidToken) {
+		return http.StatusForbidden
+	}
+	return http.StatusUnauthorized
+}
@@ -0,0 +1,226 @@
+package controller
+
+import (
+	"archive/zip"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/database"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/handler"
+)
+
+type ReturnExportVals struct {
+	Id          int        `json:"id"`
+	Status      string     `json:"status"`
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	DownloadURL string     `json:"download_url,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+}
+
+// PostExportHandler starts building a zip of every data stored about the user
+func (cfg *ApiConfig) PostExportHandler(w http.ResponseWriter, r *http.Request) {
+	userId := principal(r).UserId
+
+	job, err := db.CreateExportJob(userId, time.Now())
+	if err != nil {
+		handler.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Exports can get big, build them in the background
+	go cfg.runExport(job)
+
+	w.Header().Set("Location", fmt.Sprintf("/api/users/me/exports/%d", job.ID))
+	handler.RespondWithJSON(w, http.StatusAccepted, cfg.newReturnExportVals(job))
+}
+
+// GetExportHandler returns the status of an export job of the user.
+// Finished jobs come with a signed download url
+func (cfg *ApiConfig) GetExportHandler(w http.ResponseWriter, r *http.Request) {
+	userId := principal(r).UserId
+
+	jobId, err := strconv.Atoi(chi.URLParam(r, "exportId"))
+	if err != nil {
+		handler.RespondWithError(w, http.StatusBadRequest, "invalid export id")
+		return
+	}
+
+	job, err := db.GetExportJob(jobId)
+	if err != nil || job.UserId != userId {
+		handler.RespondWithError(w, http.StatusNotFound, database.ErrExportNotFound.Error())
+		return
+	}
+
+	handler.RespondWithJSON(w, http.StatusOK, cfg.newReturnExportVals(job))
+}
+
+// DownloadExportHandler serves a finished export.
+// The request is authorized by the signature of the url, not by a token
+func (cfg *ApiConfig) DownloadExportHandler(w http.ResponseWriter, r *http.Request) {
+	jobId, err := strconv.Atoi(chi.URLParam(r, "exportId"))
+	if err != nil {
+		handler.RespondWithError(w, http.StatusBadRequest, "invalid export id")
+		return
+	}
+
+	expires, err := strconv.ParseInt(r.URL.Query().Get("expires"), 10, 64)
+	if err != nil {
+		handler.RespondWithError(w, http.StatusForbidden, "invalid download url")
+		return
+	}
+	if time.Now().Unix() > expires {
+		handler.RespondWithError(w, http.StatusForbidden, "download url has expired")
+		return
+	}
+
+	signature, err := hex.DecodeString(r.URL.Query().Get("signature"))
+	if err != nil || !hmac.Equal(signature, cfg.signExport(jobId, expires)) {
+		handler.RespondWithError(w, http.StatusForbidden, "invalid download url")
+		return
+	}
+
+	job, err := db.GetExportJob(jobId)
+	if err != nil || job.Status != database.ExportComplete {
+		handler.RespondWithError(w, http.StatusNotFound, database.ErrExportNotFound.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="chirpy-export-%d.zip"`, job.ID))
+	http.ServeFile(w, r, job.Path)
+}
+
+func (cfg *ApiConfig) newReturnExportVals(job database.ExportJob) ReturnExportVals {
+	vals := ReturnExportVals{
+		Id:          job.ID,
+		Status:      job.Status,
+		CreatedAt:   job.CreatedAt,
+		CompletedAt: job.CompletedAt,
+		Error:       job.Error,
+	}
+
+	if job.Status == database.ExportComplete {
+		expiresAt := time.Now().Add(cfg.ExportURLTTL).UTC()
+		expires := expiresAt.Unix()
+		vals.DownloadURL = fmt.Sprintf("/api/exports/%d/download?expires=%d&signature=%s",
+			job.ID, expires, hex.EncodeToString(cfg.signExport(job.ID, expires)))
+		vals.ExpiresAt = &expiresAt
+	}
+
+	return vals
+}
+
+// signExport signs the download url of an export job until expires
+func (cfg *ApiConfig) signExport(jobId int, expires int64) []byte {
+	mac := hmac.New(sha256.New, cfg.ExportSigningKey)
+	fmt.Fprintf(mac, "export:%d:%d", jobId, expires)
+	return mac.Sum(nil)
+}
+
+// runExport builds the zip of an export job and records the outcome
+func (cfg *ApiConfig) runExport(job database.ExportJob) {
+	job.Status = database.ExportRunning
+	err := db.SaveExportJob(job)
+	if err != nil {
+		log.Printf("couldn't start export %d: %v", job.ID, err)
+		return
+	}
+
+	path, err := cfg.writeExport(job)
+	now := time.Now().UTC()
+	job.CompletedAt = &now
+	if err != nil {
+		job.Status = database.ExportFailed
+		job.Error = "couldn't build the export"
+		log.Printf("export %d failed: %v", job.ID, err)
+	} else {
+		job.Status = database.ExportComplete
+		job.Path = path
+	}
+
+	err = db.SaveExportJob(job)
+	if err != nil {
+		log.Printf("couldn't save export %d: %v", job.ID, err)
+	}
+}
+
+func (cfg *ApiConfig) writeExport(job database.ExportJob) (string, error) {
+	data, err := db.GetUserData(job.UserId)
+	if err != nil {
+		return "", err
+	}
+
+	dir := filepath.Join(cfg.ExportsDir, strconv.Itoa(job.UserId))
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(dir, fmt.Sprintf("export-%d.zip", job.ID))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	collections := make([]ReturnCollectionVals, 0, len(data.Collections))
+	for _, collection := range data.Collections {
+		collections = append(collections, newReturnCollectionVals(collection))
+	}
+
+	files := []struct {
+		name    string
+		content interface{}
+	}{
+		{"profile.json", newReturnUserVals(data.User)},
+		{"chirps.json", data.Chirps},
+		{"bookmarks.json", data.Bookmarks},
+		{"collections.json", collections},
+		{"blocks.json", data.Blocks},
+		{"mutes.json", data.Mutes},
+	}
+
+	archive := zip.NewWriter(f)
+	for _, file := range files {
+		entry, err := archive.Create(file.name)
+		if err != nil {
+			return "", err
+		}
+		encoder := json.NewEncoder(entry)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(file.content)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	err = archive.Close()
+	if err != nil {
+		return "", err
+	}
+
+	return path, f.Close()
+}
+
+// removeExports deletes the export files of purged users
+func (cfg *ApiConfig) removeExports(userIds []int) {
+	for _, userId := range userIds {
+		err := os.RemoveAll(filepath.Join(cfg.ExportsDir, strconv.Itoa(userId)))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("couldn't remove exports of user %d: %v", userId, err)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package controller
+
+import (
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/audit"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/database"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/handler"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/password"
+)
+
+// errWrongCredentials is the only error a failed login gets, so it doesn't
+// tell whether the email has an account
+var errWrongCredentials = errors.New("email or password is wrong")
+
+// throttledError is returned while the logins of an email or IP are blocked
+type throttledError struct {
+	retryAfter time.Duration
+}
+
+func (e *throttledError) Error() string {
+	return "too many failed login attempts, try again later"
+}
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     string
+)
+
+// dummyPasswordHash is compared against when the email has no account,
+// so the response takes as long as for a wrong password
+func dummyPasswordHash() string {
+	dummyHashOnce.Do(func() {
+		hash, err := password.Hash("chirpy dummy password")
+		if err != nil {
+			log.Fatal(err)
+		}
+		dummyHash = hash
+	})
+	return dummyHash
+}
+
+// authenticatePassword checks the email and password of a login. Failed
+// logins are slowed down and locked out per email and per client IP.
+// Deleted accounts are returned too, the caller decides whether they
+// can be reactivated. The failures are only forgotten by loginSucceeded,
+// once the second factor passed too
+func (cfg *ApiConfig) authenticatePassword(r *http.Request, email, plainPassword string) (database.User, error) {
+	err := cfg.checkLoginWait(r, email)
+	if err != nil {
+		return database.User{}, err
+	}
+
+	user, found, err := findLoginUser(email)
+	if err != nil {
+		return database.User{}, err
+	}
+	hash := dummyPasswordHash()
+	if found {
+		hash = user.Password
+	}
+	if password.Verify(hash, plainPassword) != nil || !found {
+		cfg.recordLoginFailure(r, email, user.ID, "password")
+		return database.User{}, errWrongCredentials
+	}
+
+	if user.IsSuspended() {
+		return database.User{}, database.ErrUserSuspended
+	}
+
+	upgradePasswordHash(user, plainPassword)
+	return user, nil
+}
+
+// upgradePasswordHash rehashes the password of the user if its hash was
+// made with an outdated algorithm or outdated parameters. Only the plain
+// password of a successful login can do that
+func upgradePasswordHash(user database.User, plainPassword string) {
+	if !password.NeedsRehash(user.Password) {
+		return
+	}
+
+	hash, err := password.Hash(plainPassword)
+	if err == nil {
+		err = db.UpgradePasswordHash(user.ID, user.Password, hash)
+	}
+	if err != nil {
+		log.Printf("couldn't upgrade the password hash of user %d: %v", user.ID, err)
+	}
+}
+
+// checkLoginSecondFactor checks the second factor of a login, wrong codes
+// count as failed logins like wrong passwords do
+func (cfg *ApiConfig) checkLoginSecondFactor(r *http.Request, user database.User, code string) error {
+	err := cfg.checkLoginWait(r, user.Email)
+	if err != nil {
+		return err
+	}
+
+	err = checkSecondFactor(user, code)
+	if errors.Is(err, database.ErrInvalidTOTPCode) {
+		cfg.recordLoginFailure(r, user.Email, user.ID, "second_factor")
+	}
+	return err
+}
+
+// loginSucceeded forgets the failed logins of the user. It must only run
+// after every factor of the login passed, or a known password would let
+// the second factor be guessed without ever being locked out
+func loginSucceeded(user database.User) error {
+	return db.ResetLoginAttempts(user.Email)
+}
+
+// checkLoginWait returns a throttledError while the email or the
+// client IP has to wait before its next login attempt
+func (cfg *ApiConfig) checkLoginWait(r *http.Request, email string) error {
+	now := time.Now()
+	wait := cfg.LoginIPAttempts.Wait(handler.ClientIP(r), now)
+
+	attempts, err := db.GetLoginAttempts(email)
+	if err != nil {
+		return err
+	}
+	if accountWait := cfg.LoginPolicy.Wait(attempts, now); accountWait > wait {
+		wait = accountWait
+	}
+
+	if wait > 0 {
+		return &throttledError{retryAfter: wait}
+	}
+	return nil
+}
+
+// recordLoginFailure counts a failed login of the email and the client IP.
+// userId is 0 when the email has no account, factor is what was wrong
+func (cfg *ApiConfig) recordLoginFailure(r *http.Request, email string, userId int, factor string) {
+	now := time.Now()
+	cfg.LoginIPAttempts.Fail(handler.ClientIP(r), now)
+
+	details := map[string]string{"email": email, "factor": factor}
+	attempts, err := db.RecordLoginFailure(email, cfg.LoginPolicy, now)
+	if err != nil {
+		log.Printf("couldn't record failed login: %v", err)
+	} else if attempts.LockedUntil != nil && attempts.Failures == 0 {
+		log.Printf("logins of %q are locked until %s", email, attempts.LockedUntil.Format(time.RFC3339))
+		details["locked_until"] = attempts.LockedUntil.Format(time.RFC3339)
+	}
+
+	cfg.audit(r, audit.LoginFailed, 0, userId, details)
+}
+
+// findLoginUser looks the user up by email, including deleted accounts
+func findLoginUser(email string) (database.User, bool, error) {
+	structure, err := db.LoadDB()
+	if err != nil {
+		return database.User{}, false, err
+	}
+
+	for _, user := range structure.Users {
+		if database.EmailsEqual(user.Email, email) {
+			return user, true, nil
+		}
+	}
+	return database.User{}, false, nil
+}
+
+// AdminUnlockUserHandler lifts the login lockout of a user.
+// Like suspensions, it can only be lifted by someone who outranks the user
+func (cfg *ApiConfig) AdminUnlockUserHandler(w http.ResponseWriter, r *http.Request) {
+	actor, err := db.GetUser(principal(r).UserId)
+	if err != nil {
+		respondWithUserError(w, err)
+		return
+	}
+
+	userId, err := strconv.Atoi(chi.URLParam(r, "userId"))
+	if err != nil {
+		handler.RespondWithError(w, http.StatusBadRequest, "invalid user id")
+		return
+	}
+
+	user, err := db.GetUser(userId)
+	if err != nil {
+		respondWithAdminError(w, err)
+		return
+	}
+	if !actor.GetRole().Outranks(user.GetRole()) {
+		respondWithAdminError(w, database.ErrNotPermitted)
+		return
+	}
+	err = db.ResetLoginAttempts(user.Email)
+	if err != nil {
+		respondWithAdminError(w, err)
+		return
+	}
+	cfg.audit(r, audit.UserUnlocked, actor.ID, user.ID, nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func respondWithLoginError(w http.ResponseWriter, err error) {
+	var throttled *throttledError
+	if errors.As(err, &throttled) {
+		seconds := int(math.Ceil(throttled.retryAfter.Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	}
+	handler.RespondWithError(w, loginErrorStatus(err), err.Error())
+}
+
+func loginErrorStatus(err error) int {
+	var throttled *throttledError
+	switch {
+	case errors.As(err, &throttled):
+		return http.StatusTooManyRequests
+	case errors.Is(err, errWrongCredentials), errors.Is(err, database.ErrInvalidTOTPCode):
+		return http.StatusUnauthorized
+	case errors.Is(err, database.ErrUserSuspended):
+		return http.StatusForbidden
+	case errors.Is(err, database.ErrTwoFactorNotEnabled):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,145 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mustafa-mun/chirpy-bootdev/internal/auth"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/database"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/keyring"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/lockout"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/password"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/totp"
+)
+
+const testPassword = "hunter2hunter2"
+
+// newTestConfig points the controller at an empty database in a temporary directory
+func newTestConfig(t *testing.T, policy lockout.Policy) *ApiConfig {
+	t.Helper()
+
+	password.Configure(password.Bcrypt{Cost: 4})
+	var err error
+	db, err = database.NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := keyring.NewHMACKey(keyring.DefaultKeyId, []byte("test secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := keyring.New(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &ApiConfig{
+		Auth:            auth.New(keys, 0),
+		LoginPolicy:     policy,
+		LoginIPAttempts: lockout.NewTracker(lockout.Policy{}),
+	}
+}
+
+// newTwoFactorUser creates a user with a confirmed second factor
+func newTwoFactorUser(t *testing.T, email string) database.User {
+	t.Helper()
+
+	user, err := db.CreateUser(testPassword, email, database.Profile{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.StartTwoFactor(user.ID, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// an older code, so the logins of the test can't reuse its counter
+	code, err := totp.Code(secret, totp.Counter(time.Now())-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.ConfirmTwoFactor(user.ID, code, nil, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func postJSON(t *testing.T, h http.HandlerFunc, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data))
+	rec := httptest.NewRecorder()
+	h(rec, req)
+	return rec
+}
+
+func TestWrongSecondFactorLocksOutDespiteRightPassword(t *testing.T) {
+	const lockoutAfter = 3
+	cfg := newTestConfig(t, lockout.Policy{
+		FreeAttempts:    lockoutAfter,
+		LockoutAfter:    lockoutAfter,
+		LockoutDuration: time.Hour,
+		ResetAfter:      time.Hour,
+	})
+	newTwoFactorUser(t, "a@b.co")
+
+	for i := 0; i < lockoutAfter; i++ {
+		rec := postJSON(t, cfg.LoginHandler, map[string]string{"email": "a@b.co", "password": testPassword})
+		if rec.Code != http.StatusOK {
+			t.Fatalf("login %d: got status %d, want %d: %s", i+1, rec.Code, http.StatusOK, rec.Body)
+		}
+		var challenge struct {
+			MFAToken string `json:"mfa_token"`
+		}
+		err := json.Unmarshal(rec.Body.Bytes(), &challenge)
+		if err != nil || challenge.MFAToken == "" {
+			t.Fatalf("login %d: no mfa token in %s", i+1, rec.Body)
+		}
+
+		rec = postJSON(t, cfg.LoginMFAHandler, map[string]string{"mfa_token": challenge.MFAToken, "code": "000000x"})
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("code %d: got status %d, want %d: %s", i+1, rec.Code, http.StatusUnauthorized, rec.Body)
+		}
+	}
+
+	rec := postJSON(t, cfg.LoginHandler, map[string]string{"email": "a@b.co", "password": testPassword})
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("login after %d wrong codes: got status %d, want %d: %s", lockoutAfter, rec.Code, http.StatusTooManyRequests, rec.Body)
+	}
+}
+
+func TestRightPasswordKeepsFailuresUntilLoginCompletes(t *testing.T) {
+	cfg := newTestConfig(t, lockout.Policy{FreeAttempts: 10, ResetAfter: time.Hour})
+	newTwoFactorUser(t, "a@b.co")
+
+	rec := postJSON(t, cfg.LoginHandler, map[string]string{"email": "a@b.co", "password": "wrongwrong"})
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong password: got status %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+	rec = postJSON(t, cfg.LoginHandler, map[string]string{"email": "a@b.co", "password": testPassword})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("right password: got status %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	attempts, err := db.GetLoginAttempts("a@b.co")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts.Failures != 1 {
+		t.Fatalf("got %d failures after the password step, want 1", attempts.Failures)
+	}
+}
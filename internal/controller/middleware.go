@@ -0,0 +1,149 @@
+package controller
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mustafa-mun/chirpy-bootdev/internal/auth"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/database"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/handler"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/ratelimit"
+)
+
+// MiddlewareAuth rejects requests without a valid access token and puts
+// the principal of the token in the request context
+func (cfg *ApiConfig) MiddlewareAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, err := cfg.authenticate(r)
+		if err != nil {
+			handler.RespondWithError(w, tokenErrorStatus(err), err.Error())
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(auth.NewContext(r.Context(), principal)))
+	})
+}
+
+// MiddlewareOptionalAuth lets anonymous requests through, requests
+// with a token or session cookie are authenticated like in MiddlewareAuth
+func (cfg *ApiConfig) MiddlewareOptionalAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" && !cfg.hasCookie(r, cfg.accessCookieName()) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cfg.MiddlewareAuth(next).ServeHTTP(w, r)
+	})
+}
+
+// MiddlewarePermission only lets users whose role grants the permission through.
+// It must run after MiddlewareAuth
+func (cfg *ApiConfig) MiddlewarePermission(permission database.Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// The role is read on every request so demotions apply right away
+			user, err := db.GetUser(principal(r).UserId)
+			if err != nil || !user.GetRole().Can(permission) {
+				handler.RespondWithError(w, http.StatusForbidden, "you don't have permission to do this")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// MiddlewareScope only lets principals granted the scope through. Logged in
+// sessions have every scope, anonymous requests are left to the route
+func (cfg *ApiConfig) MiddlewareScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			caller := principal(r)
+			if !caller.IsAnonymous() && !caller.HasScope(scope) {
+				handler.RespondWithError(w, http.StatusForbidden, "token is missing the "+scope+" scope")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// MiddlewareSessionOnly rejects scoped tokens. Routes managing the account
+// itself can only be used by the user after logging in
+func (cfg *ApiConfig) MiddlewareSessionOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if principal(r).Scopes != nil {
+			handler.RespondWithError(w, http.StatusForbidden, "this endpoint can't be used with a scoped token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RateLimit is the rate limit of a group of routes
+type RateLimit struct {
+	// Name keeps the buckets of different groups apart
+	Name  string
+	Limit ratelimit.Limit
+	// RedLimit replaces Limit for Chirpy Red members, if set
+	RedLimit ratelimit.Limit
+}
+
+// MiddlewareRateLimit limits how often each caller can use the routes.
+// Callers are told apart by their personal access token, OAuth client and
+// user, or by IP when anonymous, so it must run after the auth middleware
+func (cfg *ApiConfig) MiddlewareRateLimit(rl RateLimit) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			caller := principal(r)
+			limit := rl.Limit
+			if !caller.IsAnonymous() && rl.RedLimit.Requests > 0 {
+				user, err := db.GetUser(caller.UserId)
+				if err == nil && user.IsChirpyRed {
+					limit = rl.RedLimit
+				}
+			}
+
+			result, err := cfg.RateLimits.Take(rl.Name+":"+rateLimitKey(r, caller), limit, time.Now())
+			if err != nil {
+				// An unavailable store shouldn't take the API down with it
+				log.Printf("couldn't check the %s rate limit: %v", rl.Name, err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", limit.Requests, int(limit.Period.Seconds())))
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(limit.Requests))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+			w.Header().Set("RateLimit-Reset", strconv.Itoa(int(math.Ceil(result.Reset.Seconds()))))
+			if !result.Allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(result.RetryAfter.Seconds()))))
+				handler.RespondWithError(w, http.StatusTooManyRequests, "rate limit exceeded, try again later")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// rateLimitKey identifies the caller of the request for the rate limits
+func rateLimitKey(r *http.Request, caller auth.Principal) string {
+	switch {
+	case caller.IsAnonymous():
+		return "ip:" + handler.ClientIP(r)
+	case caller.PersonalTokenId != 0:
+		return "pat:" + strconv.Itoa(caller.PersonalTokenId)
+	case caller.ClientId != "":
+		return "client:" + caller.ClientId + ":user:" + strconv.Itoa(caller.UserId)
+	}
+	return "user:" + strconv.Itoa(caller.UserId)
+}
+
+// principal returns the authenticated caller of the request,
+// the anonymous principal on public routes
+func principal(r *http.Request) auth.Principal {
+	p, _ := auth.FromContext(r.Context())
+	return p
+}
@@ -0,0 +1,686 @@
+package controller
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/audit"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/auth"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/csrf"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/database"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/handler"
+)
+
+// authorizationCodeTTL is how long a client has to exchange an authorization code
+const authorizationCodeTTL = 10 * time.Minute
+
+type ReturnOAuthClientVals struct {
+	ClientId     string    `json:"client_id"`
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	Confidential bool      `json:"confidential"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+func newReturnOAuthClientVals(client database.OAuthClient) ReturnOAuthClientVals {
+	return ReturnOAuthClientVals{
+		ClientId:     client.ID,
+		Name:         client.Name,
+		RedirectURIs: client.RedirectURIs,
+		Confidential: client.IsConfidential(),
+		CreatedAt:    client.CreatedAt,
+	}
+}
+
+// PostOAuthClientHandler registers an OAuth client owned by the logged in user.
+// The secret of confidential clients is only returned by this request
+func (cfg *ApiConfig) PostOAuthClientHandler(w http.ResponseWriter, r *http.Request) {
+	if !cfg.checkVerified(w, principal(r).UserId, RestrictOAuthClients) {
+		return
+	}
+
+	type parameters struct {
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+		// Confidential clients run on a server and can keep a secret
+		Confidential bool `json:"confidential"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		handler.RespondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+
+	if params.Name == "" || len(params.Name) > 100 {
+		handler.RespondWithError(w, http.StatusBadRequest, "name is required and can be at most 100 characters")
+		return
+	}
+	if len(params.RedirectURIs) == 0 || len(params.RedirectURIs) > 10 {
+		handler.RespondWithError(w, http.StatusBadRequest, "between 1 and 10 redirect uris are required")
+		return
+	}
+	for _, uri := range params.RedirectURIs {
+		err = validateRedirectURI(uri)
+		if err != nil {
+			handler.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	client := database.OAuthClient{
+		ID:           newTokenId(),
+		OwnerId:      principal(r).UserId,
+		Name:         params.Name,
+		RedirectURIs: params.RedirectURIs,
+		CreatedAt:    time.Now().UTC(),
+	}
+	secret := ""
+	if params.Confidential {
+		secret = newTokenId() + newTokenId()
+		client.SecretHash = auth.HashToken(secret)
+	}
+
+	err = db.CreateOAuthClient(client)
+	if err != nil {
+		handler.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	cfg.audit(r, audit.OAuthClientCreated, client.OwnerId, client.OwnerId, map[string]string{"client_id": client.ID})
+
+	type returnVals struct {
+		ReturnOAuthClientVals
+		ClientSecret string `json:"client_secret,omitempty"`
+	}
+	respBody := returnVals{
+		ReturnOAuthClientVals: newReturnOAuthClientVals(client),
+		ClientSecret:          secret,
+	}
+
+	handler.RespondWithJSON(w, http.StatusCreated, respBody)
+}
+
+// GetOAuthClientsHandler lists the OAuth clients of the logged in user
+func (cfg *ApiConfig) GetOAuthClientsHandler(w http.ResponseWriter, r *http.Request) {
+	clients, err := db.GetUserOAuthClients(principal(r).UserId)
+	if err != nil {
+		handler.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respBody := make([]ReturnOAuthClientVals, 0, len(clients))
+	for _, client := range clients {
+		respBody = append(respBody, newReturnOAuthClientVals(client))
+	}
+
+	handler.RespondWithJSON(w, http.StatusOK, respBody)
+}
+
+// DeleteOAuthClientHandler deletes an OAuth client of the logged in user.
+// Every user who authorized the client loses the granted access
+func (cfg *ApiConfig) DeleteOAuthClientHandler(w http.ResponseWriter, r *http.Request) {
+	userId := principal(r).UserId
+	clientId := chi.URLParam(r, "clientId")
+	err := db.DeleteOAuthClient(userId, clientId, time.Now())
+	if errors.Is(err, database.ErrClientNotFound) {
+		handler.RespondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		handler.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	cfg.audit(r, audit.OAuthClientDeleted, userId, userId, map[string]string{"client_id": clientId})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// authorizeRequest is a validated request of the authorization endpoint
+type authorizeRequest struct {
+	Client        database.OAuthClient
+	RedirectURI   string
+	Scopes        []string
+	State         string
+	CodeChallenge string
+}
+
+// consentPage asks the user to log in and approve the client
+var consentPage = template.Must(template.New("consent").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Authorize {{.ClientName}} - Chirpy</title></head>
+<body>
+	{{if .Error}}<p><strong>{{.Error}}</strong></p>{{end}}
+	{{if .Form}}
+	<h1>{{.ClientName}} wants to use your Chirpy account</h1>
+	<p>It will be able to:</p>
+	<ul>{{range .Scopes}}<li>{{.}}</li>{{end}}</ul>
+	<form method="post" action="/oauth/authorize">
+		{{range $name, $value := .Form}}<input type="hidden" name="{{$name}}" value="{{$value}}">
+		{{end}}
+		<label>Email <input type="email" name="email" required></label>
+		<label>Password <input type="password" name="password" required></label>
+		<label>Two-factor code, if enabled <input type="text" name="code" autocomplete="one-time-code"></label>
+		<button type="submit" name="decision" value="approve">Authorize</button>
+		<button type="submit" name="decision" value="deny" formnovalidate>Deny</button>
+	</form>
+	{{end}}
+</body>
+</html>
+`))
+
+type consentContext struct {
+	ClientName string
+	Scopes     []string
+	Form       map[string]string
+	Error      string
+}
+
+// AuthorizeHandler is the OAuth authorization endpoint. GET shows the consent
+// page, POST logs the user in and redirects back to the client with a code
+func (cfg *ApiConfig) AuthorizeHandler(w http.ResponseWriter, r *http.Request) {
+	// The consent page must never be framed by another site
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Cache-Control", "no-store")
+
+	err := r.ParseForm()
+	if err != nil {
+		renderConsentPage(w, http.StatusBadRequest, consentContext{Error: "invalid request"})
+		return
+	}
+
+	req, redirectErr, err := parseAuthorizeRequest(r.Form)
+	if err != nil {
+		// Without a trusted redirect uri the error can only be shown to the user
+		renderConsentPage(w, http.StatusBadRequest, consentContext{Error: err.Error()})
+		return
+	}
+	if redirectErr != "" {
+		redirectWithParams(w, r, req.RedirectURI, map[string]string{
+			"error":             redirectErr,
+			"error_description": "the authorization request is invalid",
+			"state":             req.State,
+		})
+		return
+	}
+
+	page := consentContext{
+		ClientName: req.Client.Name,
+		Scopes:     req.Scopes,
+		Form: map[string]string{
+			"response_type":         "code",
+			"client_id":             req.Client.ID,
+			"redirect_uri":          req.RedirectURI,
+			"scope":                 strings.Join(req.Scopes, " "),
+			"state":                 req.State,
+			"code_challenge":        req.CodeChallenge,
+			"code_challenge_method": "S256",
+		},
+	}
+
+	// Another site could log the user in to its own account through the form
+	var csrfErr error
+	if r.Method != http.MethodGet {
+		csrfErr = cfg.CSRF.Check(r)
+	}
+	csrfToken, err := cfg.CSRF.Token(w, r)
+	if err != nil {
+		renderConsentPage(w, http.StatusInternalServerError, consentContext{Error: "something went wrong, please try again"})
+		return
+	}
+	page.Form[csrf.FormField] = csrfToken
+	if csrfErr != nil {
+		page.Error = "the form expired, please try again"
+		renderConsentPage(w, http.StatusForbidden, page)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		renderConsentPage(w, http.StatusOK, page)
+		return
+	}
+
+	if r.PostForm.Get("decision") != "approve" {
+		redirectWithParams(w, r, req.RedirectURI, map[string]string{
+			"error": "access_denied",
+			"state": req.State,
+		})
+		return
+	}
+
+	user, err := cfg.authenticatePassword(r, r.PostForm.Get("email"), r.PostForm.Get("password"))
+	if err == nil && user.IsDeleted() {
+		err = errWrongCredentials
+	}
+	if err != nil {
+		page.Error = err.Error()
+		renderConsentPage(w, loginErrorStatus(err), page)
+		return
+	}
+	// The consent form asks for the second factor together with the password
+	if user.HasTwoFactor() {
+		err = cfg.checkLoginSecondFactor(r, user, r.PostForm.Get("code"))
+		if err != nil {
+			page.Error = "enter a valid code from your authenticator app or a recovery code"
+			renderConsentPage(w, http.StatusUnauthorized, page)
+			return
+		}
+	}
+	err = loginSucceeded(user)
+	if err != nil {
+		page.Error = "something went wrong, please try again"
+		renderConsentPage(w, http.StatusInternalServerError, page)
+		return
+	}
+
+	code := newTokenId() + newTokenId()
+	err = db.CreateAuthorizationCode(database.AuthorizationCode{
+		Hash:          auth.HashToken(code),
+		ClientId:      req.Client.ID,
+		UserId:        user.ID,
+		RedirectURI:   req.RedirectURI,
+		Scopes:        req.Scopes,
+		CodeChallenge: req.CodeChallenge,
+		ExpiresAt:     time.Now().UTC().Add(authorizationCodeTTL),
+	})
+	if err != nil {
+		page.Error = "something went wrong, please try again"
+		renderConsentPage(w, http.StatusInternalServerError, page)
+		return
+	}
+	cfg.audit(r, audit.LoginSucceeded, user.ID, user.ID, map[string]string{
+		"client_id":  req.Client.ID,
+		"two_factor": strconv.FormatBool(user.HasTwoFactor()),
+	})
+
+	redirectWithParams(w, r, req.RedirectURI, map[string]string{
+		"code":  code,
+		"state": req.State,
+	})
+}
+
+// parseAuthorizeRequest validates the parameters of the authorization endpoint.
+// Unknown clients and redirect uris return an error which must not be redirected,
+// every other problem returns the OAuth error code to redirect back with
+func parseAuthorizeRequest(form url.Values) (authorizeRequest, string, error) {
+	req := authorizeRequest{State: form.Get("state")}
+
+	client, err := db.GetOAuthClient(form.Get("client_id"))
+	if err != nil {
+		return req, "", errors.New("unknown client")
+	}
+	req.Client = client
+
+	req.RedirectURI = form.Get("redirect_uri")
+	if req.RedirectURI == "" && len(client.RedirectURIs) == 1 {
+		req.RedirectURI = client.RedirectURIs[0]
+	}
+	if !client.HasRedirectURI(req.RedirectURI) {
+		return req, "", errors.New("redirect uri is not registered for this client")
+	}
+
+	if form.Get("response_type") != "code" {
+		return req, "unsupported_response_type", nil
+	}
+
+	// Every client has to use PKCE
+	req.CodeChallenge = form.Get("code_challenge")
+	if form.Get("code_challenge_method") != "S256" || len(req.CodeChallenge) != 43 {
+		return req, "invalid_request", nil
+	}
+
+	req.Scopes, err = auth.ValidateScopes(strings.Fields(form.Get("scope")))
+	if err != nil {
+		return req, "invalid_scope", nil
+	}
+
+	return req, "", nil
+}
+
+// TokenHandler is the OAuth token endpoint. It exchanges authorization
+// codes and refresh tokens for new tokens
+func (cfg *ApiConfig) TokenHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+
+	err := r.ParseForm()
+	if err != nil {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_request", "couldn't parse the request body")
+		return
+	}
+
+	client, err := authenticateClient(r)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Basic realm="chirpy"`)
+		respondWithOAuthError(w, http.StatusUnauthorized, "invalid_client", err.Error())
+		return
+	}
+
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		cfg.exchangeAuthorizationCode(w, r, client)
+	case "refresh_token":
+		cfg.exchangeRefreshToken(w, r, client)
+	default:
+		respondWithOAuthError(w, http.StatusBadRequest, "unsupported_grant_type", "grant_type must be authorization_code or refresh_token")
+	}
+}
+
+func (cfg *ApiConfig) exchangeAuthorizationCode(w http.ResponseWriter, r *http.Request, client database.OAuthClient) {
+	now := time.Now()
+	hash := auth.HashToken(r.PostForm.Get("code"))
+	code, err := db.GetAuthorizationCode(hash, now)
+	if err != nil || code.ClientId != client.ID || code.RedirectURI != r.PostForm.Get("redirect_uri") {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "authorization code is invalid")
+		return
+	}
+	if !verifyCodeChallenge(code.CodeChallenge, r.PostForm.Get("code_verifier")) {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "code verifier doesn't match the code challenge")
+		return
+	}
+
+	user, err := db.GetUser(code.UserId)
+	if err != nil || user.IsSuspended() {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "authorization code is invalid")
+		return
+	}
+
+	refreshToken, refreshRecord, err := cfg.createRefreshToken(user.ID, client.ID)
+	if err != nil {
+		respondWithOAuthError(w, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+
+	session := database.Session{
+		ID:         newTokenId(),
+		UserId:     user.ID,
+		UserAgent:  client.Name,
+		IP:         handler.ClientIP(r),
+		CreatedAt:  now.UTC(),
+		LastUsedAt: now.UTC(),
+		ExpiresAt:  refreshRecord.ExpiresAt,
+		ClientId:   client.ID,
+		Scopes:     code.Scopes,
+	}
+	err = db.RedeemAuthorizationCode(hash, session, refreshRecord, now)
+	if errors.Is(err, database.ErrCodeNotFound) || errors.Is(err, database.ErrCodeReused) {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "authorization code is invalid")
+		return
+	}
+	if err != nil {
+		respondWithOAuthError(w, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+
+	accessToken, err := cfg.createAccessToken(user.ID, session)
+	if err != nil {
+		respondWithOAuthError(w, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+
+	respondWithOAuthTokens(w, accessToken, refreshToken, session.Scopes)
+}
+
+func (cfg *ApiConfig) exchangeRefreshToken(w http.ResponseWriter, r *http.Request, client database.OAuthClient) {
+	claims, err := cfg.verifyToken(r.PostForm.Get("refresh_token"), auth.TokenTypeRefresh)
+	if err != nil || claims.ClientId != client.ID {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "refresh token is invalid")
+		return
+	}
+
+	accessToken, refreshToken, session, err := cfg.rotateTokens(claims)
+	if errors.Is(err, database.ErrTokenReused) || errors.Is(err, database.ErrTokenNotFound) ||
+		errors.Is(err, database.ErrTokenRevoked) || errors.Is(err, database.ErrSessionNotFound) {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "refresh token is invalid")
+		return
+	}
+	if err != nil {
+		respondWithOAuthError(w, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+
+	respondWithOAuthTokens(w, accessToken, refreshToken, session.Scopes)
+}
+
+// IntrospectHandler tells a confidential client whether a token issued to it
+// is active, as described in RFC 7662
+func (cfg *ApiConfig) IntrospectHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	err := r.ParseForm()
+	if err != nil {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_request", "couldn't parse the request body")
+		return
+	}
+
+	client, err := authenticateClient(r)
+	if err == nil && !client.IsConfidential() {
+		err = errors.New("only confidential clients can introspect tokens")
+	}
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Basic realm="chirpy"`)
+		respondWithOAuthError(w, http.StatusUnauthorized, "invalid_client", err.Error())
+		return
+	}
+
+	type returnVals struct {
+		Active    bool     `json:"active"`
+		Scope     string   `json:"scope,omitempty"`
+		ClientId  string   `json:"client_id,omitempty"`
+		Username  string   `json:"username,omitempty"`
+		TokenType string   `json:"token_type,omitempty"`
+		Exp       int64    `json:"exp,omitempty"`
+		Iat       int64    `json:"iat,omitempty"`
+		Sub       string   `json:"sub,omitempty"`
+		Aud       []string `json:"aud,omitempty"`
+		Iss       string   `json:"iss,omitempty"`
+		Jti       string   `json:"jti,omitempty"`
+	}
+
+	claims := cfg.findClientToken(r.PostForm.Get("token"), r.PostForm.Get("token_type_hint"), client)
+	if claims == nil {
+		handler.RespondWithJSON(w, http.StatusOK, returnVals{Active: false})
+		return
+	}
+
+	respBody := returnVals{
+		Active:   true,
+		Scope:    claims.Scope,
+		ClientId: claims.ClientId,
+		Exp:      claims.ExpiresAt.Unix(),
+		Iat:      claims.IssuedAt.Unix(),
+		Sub:      claims.Subject,
+		Aud:      claims.Audience,
+		Iss:      claims.Issuer,
+		Jti:      claims.ID,
+	}
+	if claims.Type == auth.TokenTypeAccess {
+		respBody.TokenType = "Bearer"
+	}
+	if userId, err := claims.UserId(); err == nil {
+		if user, err := db.GetUser(userId); err == nil {
+			respBody.Username = user.Handle
+		}
+	}
+
+	handler.RespondWithJSON(w, http.StatusOK, respBody)
+}
+
+// OAuthRevokeHandler revokes an access or refresh token issued to the client,
+// as described in RFC 7009. Invalid tokens are ignored
+func (cfg *ApiConfig) OAuthRevokeHandler(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_request", "couldn't parse the request body")
+		return
+	}
+
+	client, err := authenticateClient(r)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Basic realm="chirpy"`)
+		respondWithOAuthError(w, http.StatusUnauthorized, "invalid_client", err.Error())
+		return
+	}
+
+	claims := cfg.findClientToken(r.PostForm.Get("token"), r.PostForm.Get("token_type_hint"), client)
+	if claims != nil {
+		err = db.RevokeToken(claims.ID, claims.ExpiresAt.Time)
+		if err == nil && claims.Type == auth.TokenTypeRefresh {
+			// Revoking a refresh token ends the whole grant
+			err = db.RevokeRefreshToken(claims.ID, time.Now())
+			if errors.Is(err, database.ErrTokenRevoked) || errors.Is(err, database.ErrTokenNotFound) {
+				err = nil
+			}
+		}
+		if err != nil {
+			respondWithOAuthError(w, http.StatusServiceUnavailable, "server_error", err.Error())
+			return
+		}
+		userId, _ := claims.UserId()
+		cfg.audit(r, audit.TokenRevoked, 0, userId, map[string]string{
+			"token_id":  claims.ID,
+			"client_id": client.ID,
+		})
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// findClientToken returns the claims of an active token issued to the client.
+// The hint decides which token type is tried first
+func (cfg *ApiConfig) findClientToken(token, hint string, client database.OAuthClient) *auth.Claims {
+	types := []auth.TokenType{auth.TokenTypeAccess, auth.TokenTypeRefresh}
+	if hint == "refresh_token" {
+		types = []auth.TokenType{auth.TokenTypeRefresh, auth.TokenTypeAccess}
+	}
+
+	for _, typ := range types {
+		claims, err := cfg.verifyToken(token, typ)
+		if err == nil && claims.ClientId == client.ID {
+			return claims
+		}
+	}
+	return nil
+}
+
+// authenticateClient identifies the client of a token endpoint request by
+// HTTP basic auth or the client_id and client_secret form fields.
+// Confidential clients must send their secret, public clients must not
+func authenticateClient(r *http.Request) (database.OAuthClient, error) {
+	clientId, secret, ok := r.BasicAuth()
+	if ok {
+		// Basic auth credentials are form encoded
+		clientId, _ = url.QueryUnescape(clientId)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientId = r.PostForm.Get("client_id")
+		secret = r.PostForm.Get("client_secret")
+	}
+
+	client, err := db.GetOAuthClient(clientId)
+	if err != nil {
+		return database.OAuthClient{}, errors.New("unknown client")
+	}
+
+	if !client.IsConfidential() {
+		if secret != "" {
+			return database.OAuthClient{}, errors.New("public clients have no secret")
+		}
+		return client, nil
+	}
+	if subtle.ConstantTimeCompare([]byte(auth.HashToken(secret)), []byte(client.SecretHash)) != 1 {
+		return database.OAuthClient{}, errors.New("client authentication failed")
+	}
+
+	return client, nil
+}
+
+// verifyCodeChallenge checks the PKCE code verifier against the S256 challenge
+func verifyCodeChallenge(challenge, verifier string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
+
+// validateRedirectURI only accepts absolute https uris without a fragment.
+// Plain http is allowed for clients running on the loopback interface
+func validateRedirectURI(uri string) error {
+	parsed, err := url.Parse(uri)
+	if err != nil || !parsed.IsAbs() || parsed.Host == "" || parsed.Fragment != "" {
+		return errors.New("redirect uris must be absolute urls without a fragment")
+	}
+
+	host := parsed.Hostname()
+	loopback := host == "localhost" || host == "127.0.0.1" || host == "::1"
+	if parsed.Scheme != "https" && !(parsed.Scheme == "http" && loopback) {
+		return errors.New("redirect uris must use https")
+	}
+
+	return nil
+}
+
+func redirectWithParams(w http.ResponseWriter, r *http.Request, uri string, params map[string]string) {
+	target, err := url.Parse(uri)
+	if err != nil {
+		handler.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	query := target.Query()
+	for key, value := range params {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+	target.RawQuery = query.Encode()
+
+	http.Redirect(w, r, target.String(), http.StatusSeeOther)
+}
+
+func renderConsentPage(w http.ResponseWriter, code int, page consentContext) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	consentPage.Execute(w, page)
+}
+
+func respondWithOAuthTokens(w http.ResponseWriter, accessToken, refreshToken string, scopes []string) {
+	type returnVals struct {
+		AccessToken  string `json:"access_token"`
+		TokenType    string `json:"token_type"`
+		ExpiresIn    int    `json:"expires_in"`
+		RefreshToken string `json:"refresh_token"`
+		Scope        string `json:"scope"`
+	}
+	respBody := returnVals{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(accessTokenTTL.Seconds()),
+		RefreshToken: refreshToken,
+		Scope:        strings.Join(scopes, " "),
+	}
+
+	handler.RespondWithJSON(w, http.StatusOK, respBody)
+}
+
+// respondWithOAuthError writes an error response of the OAuth endpoints
+func respondWithOAuthError(w http.ResponseWriter, code int, oauthErr, description string) {
+	type returnVals struct {
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description,omitempty"`
+	}
+	handler.RespondWithJSON(w, code, returnVals{Error: oauthErr, ErrorDescription: description})
+}
@@ -0,0 +1,216 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/mustafa-mun/chirpy-bootdev/internal/audit"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/auth"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/database"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/handler"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/mailer"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/password"
+)
+
+// passwordResetTTL is how long a reset link in the mail stays valid
+const passwordResetTTL = time.Hour
+
+// PasswordResetHandler mails a password reset link to the user of the email.
+// It responds the same whether or not the email belongs to an account,
+// so it can't be used to find out who has one
+func (cfg *ApiConfig) PasswordResetHandler(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email string `json:"email"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		handler.RespondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+	if params.Email == "" {
+		handler.RespondWithError(w, http.StatusBadRequest, database.ErrEmailRequired.Error())
+		return
+	}
+
+	user, err := db.GetUserByEmail(params.Email)
+	if err != nil && !errors.Is(err, database.ErrUserNotFound) {
+		handler.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err == nil && !user.IsSuspended() {
+		err = cfg.sendPasswordReset(user)
+		if err != nil {
+			log.Printf("Couldn't send the password reset of user %d: %v", user.ID, err)
+		}
+	}
+
+	handler.RespondWithJSON(w, http.StatusAccepted, map[string]string{
+		"message": "if an account uses this email, a password reset link has been sent to it",
+	})
+}
+
+// sendPasswordReset stores a new reset token of the user and mails it.
+// The mail is sent in the background so the response doesn't take longer
+// for existing accounts
+func (cfg *ApiConfig) sendPasswordReset(user database.User) error {
+	token := newTokenId() + newTokenId()
+	err := db.CreatePasswordReset(database.PasswordReset{
+		Hash:      auth.HashToken(token),
+		UserId:    user.ID,
+		ExpiresAt: time.Now().Add(passwordResetTTL).UTC(),
+	})
+	if err != nil {
+		return err
+	}
+
+	link := cfg.PublicURL + "/reset-password?token=" + url.QueryEscape(token)
+	msg := mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your Chirpy password",
+		Body: fmt.Sprintf("Someone asked to reset the password of your Chirpy account.\n\n"+
+			"Open this link within %d minutes to choose a new password:\n%s\n\n"+
+			"If it wasn't you, ignore this mail and your password stays the same.\n",
+			int(passwordResetTTL.Minutes()), link),
+	}
+	go func() {
+		err := cfg.Mailer.Send(msg)
+		if err != nil {
+			log.Printf("Couldn't mail the password reset of user %d: %v", user.ID, err)
+		}
+	}()
+
+	return nil
+}
+
+// ConfirmPasswordResetHandler sets the new password with a token from a reset mail.
+// Every session of the user is logged out
+func (cfg *ApiConfig) ConfirmPasswordResetHandler(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		handler.RespondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+	_, err = cfg.resetPassword(r, params.Token, params.Password)
+	if err != nil {
+		handler.RespondWithError(w, resetPasswordErrorStatus(err), err.Error())
+		return
+	}
+
+	handler.RespondWithJSON(w, http.StatusOK, map[string]string{
+		"message": "your password has been reset, log in with the new password",
+	})
+}
+
+// resetPasswordPage is where the link of the reset mail leads. The form
+// posts the token back with the new password
+var resetPasswordPage = template.Must(template.New("reset-password").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Reset your password - Chirpy</title></head>
+<body>
+	{{if .Error}}<p><strong>{{.Error}}</strong></p>{{end}}
+	{{if .Message}}<p>{{.Message}}</p>{{end}}
+	{{if .Token}}
+	<h1>Choose a new Chirpy password</h1>
+	<form method="post" action="/reset-password">
+		<input type="hidden" name="token" value="{{.Token}}">
+		<label>New password <input type="password" name="password" autocomplete="new-password" required></label>
+		<button type="submit">Reset password</button>
+	</form>
+	{{end}}
+</body>
+</html>
+`))
+
+type resetPasswordContext struct {
+	Token   string
+	Message string
+	Error   string
+}
+
+// ResetPasswordPageHandler serves the page of the link in the reset mail.
+// GET shows the form, POST sets the new password
+func (cfg *ApiConfig) ResetPasswordPageHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	// The url has the token in it
+	w.Header().Set("Referrer-Policy", "no-referrer")
+
+	err := r.ParseForm()
+	if err != nil {
+		renderResetPasswordPage(w, http.StatusBadRequest, resetPasswordContext{Error: "invalid request"})
+		return
+	}
+	page := resetPasswordContext{Token: r.Form.Get("token")}
+	if page.Token == "" {
+		page.Error = database.ErrResetTokenInvalid.Error()
+		renderResetPasswordPage(w, http.StatusBadRequest, page)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		renderResetPasswordPage(w, http.StatusOK, page)
+		return
+	}
+
+	_, err = cfg.resetPassword(r, page.Token, r.PostForm.Get("password"))
+	if err != nil {
+		code := resetPasswordErrorStatus(err)
+		if code == http.StatusInternalServerError {
+			page.Error = "something went wrong, please try again"
+		} else {
+			page.Error = err.Error()
+		}
+		// Without a valid token there is nothing to try again
+		if errors.Is(err, database.ErrResetTokenInvalid) {
+			page.Token = ""
+		}
+		renderResetPasswordPage(w, code, page)
+		return
+	}
+
+	renderResetPasswordPage(w, http.StatusOK, resetPasswordContext{
+		Message: "Your password has been reset, log in with the new password.",
+	})
+}
+
+func renderResetPasswordPage(w http.ResponseWriter, code int, page resetPasswordContext) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	resetPasswordPage.Execute(w, page)
+}
+
+// resetPassword sets the new password of the user of the reset token
+func (cfg *ApiConfig) resetPassword(r *http.Request, token, newPassword string) (database.User, error) {
+	if token == "" {
+		return database.User{}, database.ErrResetTokenInvalid
+	}
+
+	user, err := db.ResetPassword(auth.HashToken(token), newPassword, time.Now())
+	if err != nil {
+		return database.User{}, err
+	}
+	cfg.audit(r, audit.PasswordReset, 0, user.ID, nil)
+	return user, nil
+}
+
+func resetPasswordErrorStatus(err error) int {
+	if errors.Is(err, database.ErrResetTokenInvalid) || errors.Is(err, database.ErrPasswordRequired) || errors.Is(err, password.ErrPolicy) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,183 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/audit"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/auth"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/database"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/handler"
+)
+
+type ReturnPersonalTokenVals struct {
+	Id         int        `json:"id"`
+	Name       string     `json:"name"`
+	Hint       string     `json:"hint"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+}
+
+func newReturnPersonalTokenVals(token database.PersonalToken) ReturnPersonalTokenVals {
+	return ReturnPersonalTokenVals{
+		Id:         token.ID,
+		Name:       token.Name,
+		Hint:       token.Hint,
+		Scopes:     token.Scopes,
+		CreatedAt:  token.CreatedAt,
+		LastUsedAt: token.LastUsedAt,
+		ExpiresAt:  token.ExpiresAt,
+	}
+}
+
+// PostPersonalTokenHandler creates a personal access token for the logged in user.
+// The token is only returned by this request
+func (cfg *ApiConfig) PostPersonalTokenHandler(w http.ResponseWriter, r *http.Request) {
+	userId := principal(r).UserId
+	if !cfg.checkVerified(w, userId, RestrictPersonalTokens) {
+		return
+	}
+
+	type parameters struct {
+		Name   string   `json:"name"`
+		Scopes []string `json:"scopes"`
+		// ExpiresInDays is optional, tokens without it never expire
+		ExpiresInDays int `json:"expires_in_days"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		handler.RespondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+
+	if params.Name == "" || len(params.Name) > 100 {
+		handler.RespondWithError(w, http.StatusBadRequest, "name is required and can be at most 100 characters")
+		return
+	}
+	if params.ExpiresInDays < 0 {
+		handler.RespondWithError(w, http.StatusBadRequest, "expires_in_days can't be negative")
+		return
+	}
+	scopes, err := auth.ValidateScopes(params.Scopes)
+	if err != nil {
+		handler.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	token, err := auth.NewPersonalToken()
+	if err != nil {
+		handler.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	now := time.Now().UTC()
+	record := database.PersonalToken{
+		UserId:    userId,
+		Name:      params.Name,
+		Hash:      auth.HashToken(token),
+		Hint:      token[:len(auth.PersonalTokenPrefix)+4],
+		Scopes:    scopes,
+		CreatedAt: now,
+	}
+	if params.ExpiresInDays > 0 {
+		expiresAt := now.AddDate(0, 0, params.ExpiresInDays)
+		record.ExpiresAt = &expiresAt
+	}
+
+	record, err = db.CreatePersonalToken(record)
+	if err != nil {
+		handler.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	cfg.audit(r, audit.PersonalTokenCreated, userId, userId, map[string]string{
+		"token_id": strconv.Itoa(record.ID),
+		"scopes":   strings.Join(record.Scopes, " "),
+	})
+
+	type returnVals struct {
+		ReturnPersonalTokenVals
+		Token string `json:"token"`
+	}
+	respBody := returnVals{
+		ReturnPersonalTokenVals: newReturnPersonalTokenVals(record),
+		Token:                   token,
+	}
+
+	handler.RespondWithJSON(w, http.StatusCreated, respBody)
+}
+
+// GetPersonalTokensHandler lists the active personal access tokens of the logged in user
+func (cfg *ApiConfig) GetPersonalTokensHandler(w http.ResponseWriter, r *http.Request) {
+	tokens, err := db.GetPersonalTokens(principal(r).UserId, time.Now())
+	if err != nil {
+		handler.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respBody := make([]ReturnPersonalTokenVals, 0, len(tokens))
+	for _, token := range tokens {
+		respBody = append(respBody, newReturnPersonalTokenVals(token))
+	}
+
+	handler.RespondWithJSON(w, http.StatusOK, respBody)
+}
+
+// DeletePersonalTokenHandler revokes a personal access token of the logged in user
+func (cfg *ApiConfig) DeletePersonalTokenHandler(w http.ResponseWriter, r *http.Request) {
+	tokenId, err := strconv.Atoi(chi.URLParam(r, "tokenId"))
+	if err != nil {
+		handler.RespondWithError(w, http.StatusBadRequest, "invalid token id")
+		return
+	}
+
+	userId := principal(r).UserId
+	err = db.RevokePersonalToken(userId, tokenId, time.Now())
+	if errors.Is(err, database.ErrPersonalTokenNotFound) {
+		handler.RespondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		handler.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	cfg.audit(r, audit.PersonalTokenRevoked, userId, userId, map[string]string{"token_id": strconv.Itoa(tokenId)})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// authenticatePersonalToken returns the principal of a personal access token,
+// which is limited to the scopes of the token
+func authenticatePersonalToken(token string) (auth.Principal, error) {
+	record, err := db.UsePersonalToken(auth.HashToken(token), time.Now())
+	if errors.Is(err, database.ErrPersonalTokenNotFound) {
+		return auth.Principal{}, auth.ErrInvalidToken
+	}
+	if err != nil {
+		return auth.Principal{}, err
+	}
+
+	user, err := db.GetUser(record.UserId)
+	if err != nil {
+		return auth.Principal{}, errors.New("token has been revoked")
+	}
+	if user.IsSuspended() {
+		return auth.Principal{}, database.ErrUserSuspended
+	}
+
+	// A token is never granted more than its scopes, even if it has none
+	return auth.Principal{
+		UserId:          user.ID,
+		PersonalTokenId: record.ID,
+		Scopes:          append([]string{}, record.Scopes...),
+	}, nil
+}
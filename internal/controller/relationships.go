@@ -0,0 +1,83 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/database"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/handler"
+)
+
+type ReturnRelationVals struct {
+	UserId int `json:"user_id"`
+}
+
+func (cfg *ApiConfig) BlockUserHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.handleRelation(w, r, db.BlockUser, http.StatusCreated)
+}
+
+func (cfg *ApiConfig) UnblockUserHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.handleRelation(w, r, db.UnblockUser, http.StatusOK)
+}
+
+func (cfg *ApiConfig) MuteUserHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.handleRelation(w, r, db.MuteUser, http.StatusCreated)
+}
+
+func (cfg *ApiConfig) UnmuteUserHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.handleRelation(w, r, db.UnmuteUser, http.StatusOK)
+}
+
+func (cfg *ApiConfig) GetBlocksHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.listRelations(w, r, db.GetBlockedUsers)
+}
+
+func (cfg *ApiConfig) GetMutesHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.listRelations(w, r, db.GetMutedUsers)
+}
+
+// handleRelation creates or removes a block or mute between
+// the logged in user and the user in the url
+func (cfg *ApiConfig) handleRelation(w http.ResponseWriter, r *http.Request, update func(userId, targetId int) error, code int) {
+	userId := principal(r).UserId
+
+	targetId, err := strconv.Atoi(chi.URLParam(r, "userId"))
+	if err != nil {
+		handler.RespondWithError(w, http.StatusBadRequest, "invalid user id")
+		return
+	}
+
+	err = update(userId, targetId)
+	if err != nil {
+		switch {
+		case errors.Is(err, database.ErrUserNotFound), errors.Is(err, database.ErrNoRelation):
+			handler.RespondWithError(w, http.StatusNotFound, err.Error())
+		case errors.Is(err, database.ErrSelfRelation):
+			handler.RespondWithError(w, http.StatusBadRequest, err.Error())
+		default:
+			handler.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	handler.RespondWithJSON(w, code, ReturnRelationVals{UserId: targetId})
+}
+
+func (cfg *ApiConfig) listRelations(w http.ResponseWriter, r *http.Request, list func(userId int) ([]int, error)) {
+	userId := principal(r).UserId
+
+	ids, err := list(userId)
+	if err != nil {
+		handler.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respBody := make([]ReturnRelationVals, 0, len(ids))
+	for _, id := range ids {
+		respBody = append(respBody, ReturnRelationVals{UserId: id})
+	}
+
+	handler.RespondWithJSON(w, http.StatusOK, respBody)
+}
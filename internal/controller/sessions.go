@@ -0,0 +1,89 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/audit"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/database"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/handler"
+)
+
+type ReturnSessionVals struct {
+	Id         string    `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	// Current marks the session the request was made with
+	Current bool `json:"current"`
+	// ClientId and Scopes are set on access granted to an OAuth client
+	ClientId string   `json:"client_id,omitempty"`
+	Scopes   []string `json:"scopes,omitempty"`
+}
+
+// GetSessionsHandler lists the active sessions of the logged in user
+func (cfg *ApiConfig) GetSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	caller := principal(r)
+	userId, sessionId := caller.UserId, caller.SessionId
+
+	sessions, err := db.GetUserSessions(userId, time.Now())
+	if err != nil {
+		handler.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respBody := make([]ReturnSessionVals, 0, len(sessions))
+	for _, session := range sessions {
+		respBody = append(respBody, ReturnSessionVals{
+			Id:         session.ID,
+			UserAgent:  session.UserAgent,
+			IP:         session.IP,
+			CreatedAt:  session.CreatedAt,
+			LastUsedAt: session.LastUsedAt,
+			ExpiresAt:  session.ExpiresAt,
+			Current:    session.ID == sessionId,
+			ClientId:   session.ClientId,
+			Scopes:     session.Scopes,
+		})
+	}
+
+	handler.RespondWithJSON(w, http.StatusOK, respBody)
+}
+
+// DeleteSessionHandler revokes a single session of the logged in user
+func (cfg *ApiConfig) DeleteSessionHandler(w http.ResponseWriter, r *http.Request) {
+	userId := principal(r).UserId
+
+	sessionId := chi.URLParam(r, "sessionId")
+	err := db.RevokeSession(userId, sessionId, time.Now())
+	if errors.Is(err, database.ErrSessionNotFound) {
+		handler.RespondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		handler.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	cfg.audit(r, audit.SessionRevoked, userId, userId, map[string]string{"session_id": sessionId})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteAllSessionsHandler logs the user out everywhere.
+// Every refresh and access token of the user stops working
+func (cfg *ApiConfig) DeleteAllSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	userId := principal(r).UserId
+
+	err := db.RevokeAllSessions(userId, time.Now())
+	if err != nil {
+		respondWithUserError(w, err)
+		return
+	}
+	cfg.audit(r, audit.SessionsRevoked, userId, userId, nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,329 @@
+package controller
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/audit"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/auth"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/database"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/handler"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/password"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/totp"
+)
+
+const (
+	// mfaTokenTTL is how long a login can wait for its second factor
+	mfaTokenTTL       = 5 * time.Minute
+	recoveryCodeCount = 10
+	totpIssuer        = "Chirpy"
+)
+
+// PostTwoFactorHandler starts the TOTP enrollment of the logged in user.
+// It returns the secret as an otpauth uri and a QR code to scan with an
+// authenticator app, the second factor is enabled once a code is confirmed
+func (cfg *ApiConfig) PostTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := checkCurrentPassword(w, r)
+	if !ok {
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		handler.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	err = db.StartTwoFactor(user.ID, secret)
+	if err != nil {
+		respondWithTwoFactorError(w, err)
+		return
+	}
+
+	uri := totp.URI(totpIssuer, user.Email, secret)
+	png, err := totp.QRCode(uri)
+	if err != nil {
+		handler.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	type returnVals struct {
+		Secret     string `json:"secret"`
+		OtpauthURI string `json:"otpauth_uri"`
+		// QRCode is a PNG data uri which can be used as the src of an img
+		QRCode string `json:"qr_code"`
+	}
+	handler.RespondWithJSON(w, http.StatusOK, returnVals{
+		Secret:     secret,
+		OtpauthURI: uri,
+		QRCode:     "data:image/png;base64," + base64.StdEncoding.EncodeToString(png),
+	})
+}
+
+// ConfirmTwoFactorHandler enables the second factor with a first code from
+// the app. The recovery codes are only returned by this request
+func (cfg *ApiConfig) ConfirmTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Code string `json:"code"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		handler.RespondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		handler.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	userId := principal(r).UserId
+	err = db.ConfirmTwoFactor(userId, params.Code, hashes, time.Now())
+	if err != nil {
+		respondWithTwoFactorError(w, err)
+		return
+	}
+	cfg.audit(r, audit.TwoFactorEnabled, userId, userId, nil)
+
+	handler.RespondWithJSON(w, http.StatusOK, map[string][]string{"recovery_codes": codes})
+}
+
+// PostRecoveryCodesHandler replaces the recovery codes of the user with new ones
+func (cfg *ApiConfig) PostRecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := checkCurrentPassword(w, r)
+	if !ok {
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		handler.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	err = db.ReplaceRecoveryCodes(user.ID, hashes)
+	if err != nil {
+		respondWithTwoFactorError(w, err)
+		return
+	}
+	cfg.audit(r, audit.RecoveryCodesReplaced, user.ID, user.ID, nil)
+
+	handler.RespondWithJSON(w, http.StatusOK, map[string][]string{"recovery_codes": codes})
+}
+
+// DeleteTwoFactorHandler turns the second factor off. It needs the password
+// and a code, so a stolen session alone can't remove it
+func (cfg *ApiConfig) DeleteTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		handler.RespondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+
+	user, err := db.GetUser(principal(r).UserId)
+	if err != nil {
+		respondWithUserError(w, err)
+		return
+	}
+	if password.Verify(user.Password, params.Password) != nil {
+		handler.RespondWithError(w, http.StatusUnauthorized, "password is wrong")
+		return
+	}
+	err = checkSecondFactor(user, params.Code)
+	if err != nil {
+		respondWithTwoFactorError(w, err)
+		return
+	}
+
+	err = db.DisableTwoFactor(user.ID)
+	if err != nil {
+		respondWithTwoFactorError(w, err)
+		return
+	}
+	cfg.audit(r, audit.TwoFactorDisabled, user.ID, user.ID, nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// LoginMFAHandler finishes a login of a user with a second factor. It takes
+// the challenge token of the login and a TOTP or recovery code
+func (cfg *ApiConfig) LoginMFAHandler(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		MFAToken   string `json:"mfa_token"`
+		Code       string `json:"code"`
+		UseCookies bool   `json:"use_cookies"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		handler.RespondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+	if params.UseCookies && !cfg.CookieAuth {
+		handler.RespondWithError(w, http.StatusBadRequest, errCookiesDisabled.Error())
+		return
+	}
+
+	claims, err := cfg.Auth.Parse(params.MFAToken, auth.TokenTypeMFA)
+	if err != nil {
+		handler.RespondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	revoked, err := db.IsTokenRevoked(claims.ID)
+	if err != nil {
+		handler.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if revoked {
+		handler.RespondWithError(w, http.StatusUnauthorized, "token has been revoked")
+		return
+	}
+	userId, err := claims.UserId()
+	if err != nil {
+		handler.RespondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	// The account may be deleted and waiting to be reactivated by this login
+	structure, err := db.LoadDB()
+	if err != nil {
+		handler.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	user, ok := structure.Users[userId]
+	if !ok || claims.IssuedAt.Unix() < user.TokensValidAfter {
+		handler.RespondWithError(w, http.StatusUnauthorized, "token has been revoked")
+		return
+	}
+	if user.IsSuspended() {
+		handler.RespondWithError(w, http.StatusForbidden, database.ErrUserSuspended.Error())
+		return
+	}
+
+	err = cfg.checkLoginSecondFactor(r, user, params.Code)
+	if err != nil {
+		respondWithLoginError(w, err)
+		return
+	}
+
+	// The challenge can't be answered again
+	err = db.RevokeToken(claims.ID, claims.ExpiresAt.Time)
+	if err != nil {
+		handler.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	cfg.completeLogin(w, r, user, params.UseCookies)
+}
+
+// createMFAToken returns the challenge token of a login waiting for its second factor
+func (cfg *ApiConfig) createMFAToken(userId int) (string, error) {
+	return cfg.Auth.Sign(auth.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject: strconv.Itoa(userId),
+			ID:      newTokenId(),
+		},
+		Type: auth.TokenTypeMFA,
+	}, mfaTokenTTL)
+}
+
+// checkSecondFactor accepts either a TOTP code or a recovery code of the user
+func checkSecondFactor(user database.User, code string) error {
+	if !user.HasTwoFactor() {
+		return database.ErrTwoFactorNotEnabled
+	}
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return database.ErrInvalidTOTPCode
+	}
+
+	if len(code) == totp.Digits {
+		return db.UseTwoFactorCode(user.ID, code, time.Now())
+	}
+	_, err := db.UseRecoveryCode(user.ID, auth.HashToken(normalizeRecoveryCode(code)))
+	return err
+}
+
+// checkCurrentPassword decodes the password of the request body and checks
+// it against the logged in user
+func checkCurrentPassword(w http.ResponseWriter, r *http.Request) (database.User, bool) {
+	type parameters struct {
+		Password string `json:"password"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		handler.RespondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return database.User{}, false
+	}
+
+	user, err := db.GetUser(principal(r).UserId)
+	if err != nil {
+		respondWithUserError(w, err)
+		return database.User{}, false
+	}
+	if password.Verify(user.Password, params.Password) != nil {
+		handler.RespondWithError(w, http.StatusUnauthorized, "password is wrong")
+		return database.User{}, false
+	}
+
+	return user, true
+}
+
+// newRecoveryCodes returns new recovery codes to show to the user
+// together with the hashes to store
+func newRecoveryCodes() (codes, hashes []string, err error) {
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+	for i := 0; i < recoveryCodeCount; i++ {
+		// 50 random bits, encoded into 10 characters
+		b := make([]byte, 7)
+		_, err := rand.Read(b)
+		if err != nil {
+			return nil, nil, err
+		}
+		raw := strings.ToLower(encoding.EncodeToString(b))[:10]
+		codes = append(codes, raw[:5]+"-"+raw[5:])
+		hashes = append(hashes, auth.HashToken(raw))
+	}
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode accepts the code with or without its dash and in any case
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+func respondWithTwoFactorError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, database.ErrUserNotFound):
+		handler.RespondWithError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, database.ErrTwoFactorEnabled):
+		handler.RespondWithError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, database.ErrInvalidTOTPCode):
+		handler.RespondWithError(w, http.StatusUnauthorized, err.Error())
+	case errors.Is(err, database.ErrTwoFactorNotEnabled), errors.Is(err, database.ErrTwoFactorNotStarted):
+		handler.RespondWithError(w, http.StatusBadRequest, err.Error())
+	default:
+		handler.RespondWithError(w, http.StatusInternalServerError, err.Error())
+	}
+}
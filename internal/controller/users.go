@@ -0,0 +1,190 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/audit"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/database"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/handler"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/password"
+)
+
+// PublicUserVals is the public profile of a user.
+// It must never contain the email or the password hash of the user
+type PublicUserVals struct {
+	Id          int    `json:"id"`
+	Handle      string `json:"handle"`
+	DisplayName string `json:"display_name"`
+	Bio         string `json:"bio"`
+	AvatarURL   string `json:"avatar_url"`
+	IsChirpyRed bool   `json:"is_chirpy_red"`
+}
+
+func newPublicUserVals(user database.User) PublicUserVals {
+	return PublicUserVals{
+		Id:          user.ID,
+		Handle:      user.Handle,
+		DisplayName: user.DisplayName,
+		Bio:         user.Bio,
+		AvatarURL:   user.AvatarURL,
+		IsChirpyRed: user.IsChirpyRed,
+	}
+}
+
+func (cfg *ApiConfig) GetUserHandler(w http.ResponseWriter, r *http.Request) {
+	viewerId := principal(r).UserId
+
+	user, err := findUser(chi.URLParam(r, "userId"))
+	if err != nil {
+		respondWithUserError(w, err)
+		return
+	}
+
+	// Users who blocked each other can't see each other's profiles
+	blocked, err := db.IsBlocked(viewerId, user.ID)
+	if err != nil {
+		handler.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if blocked {
+		respondWithUserError(w, database.ErrUserNotFound)
+		return
+	}
+
+	handler.RespondWithJSON(w, http.StatusOK, newPublicUserVals(user))
+}
+
+func (cfg *ApiConfig) GetUserChirpsHandler(w http.ResponseWriter, r *http.Request) {
+	viewerId := principal(r).UserId
+
+	user, err := findUser(chi.URLParam(r, "userId"))
+	if err != nil {
+		respondWithUserError(w, err)
+		return
+	}
+
+	chirps, err := db.GetUserChirps(user.ID, r.URL.Query().Get("sort"), viewerId)
+	if err != nil {
+		respondWithUserError(w, err)
+		return
+	}
+
+	handler.RespondWithJSON(w, http.StatusOK, chirps)
+}
+
+func (cfg *ApiConfig) GetMeHandler(w http.ResponseWriter, r *http.Request) {
+	userId := principal(r).UserId
+
+	user, err := db.GetUser(userId)
+	if err != nil {
+		respondWithUserError(w, err)
+		return
+	}
+
+	handler.RespondWithJSON(w, http.StatusOK, newReturnUserVals(user))
+}
+
+// PatchMeHandler updates only the fields supplied in the request body.
+// Changing the email or the password requires the current password
+func (cfg *ApiConfig) PatchMeHandler(w http.ResponseWriter, r *http.Request) {
+	userId := principal(r).UserId
+
+	type parameters struct {
+		Email           *string `json:"email"`
+		Password        *string `json:"password"`
+		CurrentPassword string  `json:"current_password"`
+		Handle          *string `json:"handle"`
+		DisplayName     *string `json:"display_name"`
+		Bio             *string `json:"bio"`
+		AvatarURL       *string `json:"avatar_url"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		handler.RespondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+
+	if (params.Email != nil || params.Password != nil) && !checkPasswordForChange(w, userId, params.CurrentPassword) {
+		return
+	}
+
+	patch := database.UserPatch{
+		Email:       params.Email,
+		Password:    params.Password,
+		Handle:      params.Handle,
+		DisplayName: params.DisplayName,
+		Bio:         params.Bio,
+		AvatarURL:   params.AvatarURL,
+	}
+	updatedUser, err := db.PatchUser(userId, patch)
+	if err != nil {
+		respondWithUserError(w, err)
+		return
+	}
+	cfg.auditUserPatch(r, updatedUser, patch)
+	if params.Email != nil && !updatedUser.EmailVerified {
+		cfg.sendEmailVerification(updatedUser)
+	}
+
+	handler.RespondWithJSON(w, http.StatusOK, newReturnUserVals(updatedUser))
+}
+
+// checkPasswordForChange makes sure the user knows their current password
+// before changing their email or password, so a stolen token alone can't
+// take the account over. It responds to the request if not
+func checkPasswordForChange(w http.ResponseWriter, userId int, currentPassword string) bool {
+	if currentPassword == "" {
+		handler.RespondWithError(w, http.StatusBadRequest, "current password is required to change email or password")
+		return false
+	}
+
+	user, err := db.GetUser(userId)
+	if err != nil {
+		respondWithUserError(w, err)
+		return false
+	}
+
+	err = password.Verify(user.Password, currentPassword)
+	if err != nil {
+		handler.RespondWithError(w, http.StatusUnauthorized, "current password is wrong")
+		return false
+	}
+	return true
+}
+
+// auditUserPatch records the changes of a patch the audit log cares about
+func (cfg *ApiConfig) auditUserPatch(r *http.Request, user database.User, patch database.UserPatch) {
+	if patch.Email != nil {
+		cfg.audit(r, audit.EmailChanged, user.ID, user.ID, map[string]string{"email": user.Email})
+	}
+	if patch.Password != nil {
+		cfg.audit(r, audit.PasswordChanged, user.ID, user.ID, nil)
+	}
+}
+
+// findUser looks a user up by numeric id or by @handle
+func findUser(idOrHandle string) (database.User, error) {
+	if userId, err := strconv.Atoi(idOrHandle); err == nil {
+		return db.GetUser(userId)
+	}
+	return db.GetUserByHandle(idOrHandle)
+}
+
+func respondWithUserError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, database.ErrUserNotFound):
+		handler.RespondWithError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, database.ErrHandleTaken), errors.Is(err, database.ErrEmailTaken):
+		handler.RespondWithError(w, http.StatusConflict, err.Error())
+	default:
+		// everything else is a validation error of the supplied fields
+		handler.RespondWithError(w, http.StatusBadRequest, err.Error())
+	}
+}
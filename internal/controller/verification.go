@@ -0,0 +1,239 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mustafa-mun/chirpy-bootdev/internal/audit"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/auth"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/database"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/handler"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/mailer"
+)
+
+// emailVerificationTTL is how long a verification link in the mail stays valid
+const emailVerificationTTL = 24 * time.Hour
+
+// Restriction is something users can't do until they verify their email
+type Restriction string
+
+const (
+	RestrictPostChirps     Restriction = "post_chirps"
+	RestrictPersonalTokens Restriction = "personal_tokens"
+	RestrictOAuthClients   Restriction = "oauth_clients"
+)
+
+// ParseRestrictions parses a comma separated list of restrictions,
+// an empty value or "none" restricts nothing
+func ParseRestrictions(value string) ([]Restriction, error) {
+	restrictions := make([]Restriction, 0)
+	if strings.TrimSpace(value) == "none" {
+		return restrictions, nil
+	}
+
+	for _, name := range strings.Split(value, ",") {
+		restriction := Restriction(strings.TrimSpace(name))
+		switch restriction {
+		case "":
+			continue
+		case RestrictPostChirps, RestrictPersonalTokens, RestrictOAuthClients:
+			restrictions = append(restrictions, restriction)
+		default:
+			return nil, fmt.Errorf("unknown restriction %q, must be one of %s, %s or %s",
+				restriction, RestrictPostChirps, RestrictPersonalTokens, RestrictOAuthClients)
+		}
+	}
+	return restrictions, nil
+}
+
+// checkVerified responds with 403 and returns false when the user
+// hasn't verified their email and the restriction applies to unverified users
+func (cfg *ApiConfig) checkVerified(w http.ResponseWriter, userId int, restriction Restriction) bool {
+	restricted := false
+	for _, r := range cfg.UnverifiedRestrictions {
+		if r == restriction {
+			restricted = true
+		}
+	}
+	if !restricted {
+		return true
+	}
+
+	user, err := db.GetUser(userId)
+	if err != nil {
+		respondWithUserError(w, err)
+		return false
+	}
+	if !user.EmailVerified {
+		handler.RespondWithError(w, http.StatusForbidden, "verify your email to do this")
+		return false
+	}
+	return true
+}
+
+// sendEmailVerification stores a new verification token of the user and
+// mails it to their email. Sending failures are only logged, the user
+// can ask for a new link
+func (cfg *ApiConfig) sendEmailVerification(user database.User) {
+	token := newTokenId() + newTokenId()
+	err := db.CreateEmailVerification(database.EmailVerification{
+		Hash:      auth.HashToken(token),
+		UserId:    user.ID,
+		Email:     user.Email,
+		ExpiresAt: time.Now().Add(emailVerificationTTL).UTC(),
+	})
+	if err != nil {
+		log.Printf("Couldn't create the email verification of user %d: %v", user.ID, err)
+		return
+	}
+
+	link := cfg.PublicURL + "/verify-email?token=" + url.QueryEscape(token)
+	msg := mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your Chirpy email",
+		Body: fmt.Sprintf("Open this link within %d hours to verify the email of your Chirpy account:\n%s\n\n"+
+			"If you didn't sign up for Chirpy, ignore this mail.\n",
+			int(emailVerificationTTL.Hours()), link),
+	}
+	go func() {
+		err := cfg.Mailer.Send(msg)
+		if err != nil {
+			log.Printf("Couldn't mail the email verification of user %d: %v", user.ID, err)
+		}
+	}()
+}
+
+// VerifyEmailHandler verifies the email with a token from a verification mail
+func (cfg *ApiConfig) VerifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token string `json:"token"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		handler.RespondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+
+	user, err := cfg.verifyEmail(r, params.Token)
+	if errors.Is(err, database.ErrVerificationInvalid) {
+		handler.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		handler.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	handler.RespondWithJSON(w, http.StatusOK, newReturnUserVals(user))
+}
+
+// verifyEmailPage is where the link of the verification mail leads. Mail
+// scanners open links on their own, so the email is only verified once
+// the user submits the form
+var verifyEmailPage = template.Must(template.New("verify-email").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Verify your email - Chirpy</title></head>
+<body>
+	{{if .Error}}<p><strong>{{.Error}}</strong></p>{{end}}
+	{{if .Message}}<p>{{.Message}}</p>{{end}}
+	{{if .Token}}
+	<h1>Verify the email of your Chirpy account</h1>
+	<form method="post" action="/verify-email">
+		<input type="hidden" name="token" value="{{.Token}}">
+		<button type="submit">Verify email</button>
+	</form>
+	{{end}}
+</body>
+</html>
+`))
+
+type verifyEmailContext struct {
+	Token   string
+	Message string
+	Error   string
+}
+
+// VerifyEmailPageHandler serves the page of the link in the verification mail.
+// GET shows the form, POST verifies the email
+func (cfg *ApiConfig) VerifyEmailPageHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	// The url has the token in it
+	w.Header().Set("Referrer-Policy", "no-referrer")
+
+	err := r.ParseForm()
+	if err != nil {
+		renderVerifyEmailPage(w, http.StatusBadRequest, verifyEmailContext{Error: "invalid request"})
+		return
+	}
+	page := verifyEmailContext{Token: r.Form.Get("token")}
+	if page.Token == "" {
+		page.Error = database.ErrVerificationInvalid.Error()
+		renderVerifyEmailPage(w, http.StatusBadRequest, page)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		renderVerifyEmailPage(w, http.StatusOK, page)
+		return
+	}
+
+	user, err := cfg.verifyEmail(r, page.Token)
+	if errors.Is(err, database.ErrVerificationInvalid) {
+		renderVerifyEmailPage(w, http.StatusBadRequest, verifyEmailContext{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		page.Error = "something went wrong, please try again"
+		renderVerifyEmailPage(w, http.StatusInternalServerError, page)
+		return
+	}
+
+	renderVerifyEmailPage(w, http.StatusOK, verifyEmailContext{
+		Message: user.Email + " has been verified.",
+	})
+}
+
+func renderVerifyEmailPage(w http.ResponseWriter, code int, page verifyEmailContext) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	verifyEmailPage.Execute(w, page)
+}
+
+// verifyEmail verifies the email of the verification token
+func (cfg *ApiConfig) verifyEmail(r *http.Request, token string) (database.User, error) {
+	user, err := db.VerifyEmail(auth.HashToken(token), time.Now())
+	if err != nil {
+		return database.User{}, err
+	}
+	cfg.audit(r, audit.EmailVerified, 0, user.ID, map[string]string{"email": user.Email})
+	return user, nil
+}
+
+// ResendVerificationHandler mails a new verification link to the logged in user
+func (cfg *ApiConfig) ResendVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := db.GetUser(principal(r).UserId)
+	if err != nil {
+		respondWithUserError(w, err)
+		return
+	}
+	if user.EmailVerified {
+		handler.RespondWithError(w, http.StatusConflict, "email is already verified")
+		return
+	}
+
+	cfg.sendEmailVerification(user)
+
+	handler.RespondWithJSON(w, http.StatusAccepted, map[string]string{
+		"message": "a verification link has been sent to " + user.Email,
+	})
+}
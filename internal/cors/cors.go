@@ -0,0 +1,213 @@
+// Package cors answers cross-origin requests by a policy per route group.
+package cors
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Policy is what cross-origin requests of a route group may do
+type Policy struct {
+	// AllowedOrigins are origins like "https://chirpy.app". "https://*.chirpy.app"
+	// allows every subdomain and "*" every origin. No origins allow none
+	AllowedOrigins []string
+	AllowedMethods []string
+	// AllowedHeaders are the request headers clients may send, "*" allows any
+	AllowedHeaders []string
+	// ExposedHeaders are the response headers scripts may read
+	ExposedHeaders []string
+	// AllowCredentials lets browsers send cookies with the requests
+	AllowCredentials bool
+	// MaxAge is how long browsers may cache a preflight response
+	MaxAge time.Duration
+}
+
+var ErrInvalidPolicy = errors.New("invalid CORS policy")
+
+// Validate checks the policy for origins browsers would never accept
+func (p Policy) Validate() error {
+	for _, origin := range p.AllowedOrigins {
+		if origin == "*" {
+			if p.AllowCredentials {
+				return fmt.Errorf("%w: credentials can't be allowed for every origin", ErrInvalidPolicy)
+			}
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+			(u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" {
+			return fmt.Errorf("%w: origin %q must look like https://example.com", ErrInvalidPolicy, origin)
+		}
+		if strings.Contains(strings.TrimPrefix(u.Host, "*."), "*") {
+			return fmt.Errorf("%w: origin %q can only have a wildcard as its first label", ErrInvalidPolicy, origin)
+		}
+	}
+	return nil
+}
+
+// allowsOrigin reports whether the origin of a request matches the policy
+func (p Policy) allowsOrigin(origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, allowed := range p.AllowedOrigins {
+		allowed = strings.TrimSuffix(strings.ToLower(allowed), "/")
+		switch {
+		case allowed == "*", allowed == origin:
+			return true
+		case strings.Contains(allowed, "://*."):
+			// https://*.chirpy.app matches https://a.chirpy.app but not https://chirpy.app
+			scheme, domain, _ := strings.Cut(allowed, "://*")
+			if strings.HasPrefix(origin, scheme+"://") && strings.HasSuffix(origin, domain) &&
+				len(origin) > len(scheme+"://")+len(domain) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (p Policy) allowsAnyOrigin() bool {
+	for _, allowed := range p.AllowedOrigins {
+		if allowed == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+func (p Policy) allowsMethod(method string) bool {
+	for _, allowed := range p.AllowedMethods {
+		if strings.EqualFold(allowed, method) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p Policy) allowsHeaders(headers []string) bool {
+	for _, header := range headers {
+		if !p.allowsHeader(header) {
+			return false
+		}
+	}
+	return true
+}
+
+func (p Policy) allowsHeader(header string) bool {
+	for _, allowed := range p.AllowedHeaders {
+		if allowed == "*" || strings.EqualFold(allowed, header) {
+			return true
+		}
+	}
+	return false
+}
+
+// Handler answers preflight requests and adds the CORS headers to the
+// responses of next. Requests of other origins are still served, browsers
+// just don't let scripts read their responses
+func (p Policy) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the response depends on the origin, so caches must keep them apart
+		w.Header().Add("Vary", "Origin")
+
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			p.preflight(w, r, origin)
+			return
+		}
+
+		if p.allowsOrigin(origin) {
+			p.allowOrigin(w, origin)
+			if len(p.ExposedHeaders) > 0 {
+				w.Header().Set("Access-Control-Expose-Headers", strings.Join(p.ExposedHeaders, ", "))
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// preflight answers whether the browser may send the actual request
+func (p Policy) preflight(w http.ResponseWriter, r *http.Request, origin string) {
+	w.Header().Add("Vary", "Access-Control-Request-Method")
+	w.Header().Add("Vary", "Access-Control-Request-Headers")
+
+	method := r.Header.Get("Access-Control-Request-Method")
+	headers := requestedHeaders(r)
+	if !p.allowsOrigin(origin) || !p.allowsMethod(method) || !p.allowsHeaders(headers) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	p.allowOrigin(w, origin)
+	w.Header().Set("Access-Control-Allow-Methods", strings.Join(p.AllowedMethods, ", "))
+	if len(headers) > 0 {
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+	}
+	if p.MaxAge > 0 {
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(p.MaxAge.Seconds())))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (p Policy) allowOrigin(w http.ResponseWriter, origin string) {
+	if p.allowsAnyOrigin() && !p.AllowCredentials {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+	if p.AllowCredentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// requestedHeaders returns the headers of the Access-Control-Request-Headers of a preflight
+func requestedHeaders(r *http.Request) []string {
+	headers := make([]string, 0)
+	for _, value := range r.Header.Values("Access-Control-Request-Headers") {
+		for _, header := range strings.Split(value, ",") {
+			if header = strings.TrimSpace(header); header != "" {
+				headers = append(headers, http.CanonicalHeaderKey(header))
+			}
+		}
+	}
+	return headers
+}
+
+// Groups applies the policy of the route group a request belongs to.
+// Groups are path prefixes like "/api", the longest matching one wins and
+// requests outside of every group get the fallback policy
+func Groups(fallback Policy, groups map[string]Policy) func(http.Handler) http.Handler {
+	prefixes := make([]string, 0, len(groups))
+	for prefix := range groups {
+		prefixes = append(prefixes, strings.TrimSuffix(prefix, "/"))
+	}
+	sort.Slice(prefixes, func(i, j int) bool { return len(prefixes[i]) > len(prefixes[j]) })
+
+	return func(next http.Handler) http.Handler {
+		handlers := make(map[string]http.Handler, len(groups))
+		for prefix, policy := range groups {
+			handlers[strings.TrimSuffix(prefix, "/")] = policy.Handler(next)
+		}
+		fallbackHandler := fallback.Handler(next)
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, prefix := range prefixes {
+				if r.URL.Path == prefix || strings.HasPrefix(r.URL.Path, prefix+"/") {
+					handlers[prefix].ServeHTTP(w, r)
+					return
+				}
+			}
+			fallbackHandler.ServeHTTP(w, r)
+		})
+	}
+}
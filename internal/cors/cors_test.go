@@ -0,0 +1,227 @@
+package cors
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+var testPolicy = Policy{
+	AllowedOrigins: []string{"https://example.com", "https://*.chirpy.app"},
+	AllowedMethods: []string{"GET", "POST", "PATCH"},
+	AllowedHeaders: []string{"Authorization", "Content-Type"},
+	ExposedHeaders: []string{"Retry-After"},
+}
+
+// serve sends the request through the policy and reports whether it reached next
+func serve(p Policy, r *http.Request) (*httptest.ResponseRecorder, bool) {
+	reached := false
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+		w.WriteHeader(http.StatusOK)
+	})
+	rec := httptest.NewRecorder()
+	p.Handler(next).ServeHTTP(rec, r)
+	return rec, reached
+}
+
+func TestAllowsOrigin(t *testing.T) {
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{origin: "https://example.com", want: true},
+		{origin: "HTTPS://EXAMPLE.COM", want: true},
+		{origin: "http://example.com", want: false},
+		{origin: "https://evil-example.com", want: false},
+		{origin: "https://example.com.evil.com", want: false},
+		{origin: "https://sub.example.com", want: false},
+		{origin: "https://example.com:8443", want: false},
+		{origin: "https://a.chirpy.app", want: true},
+		{origin: "https://a.b.chirpy.app", want: true},
+		{origin: "https://chirpy.app", want: false},
+		{origin: "https://.chirpy.app", want: false},
+		{origin: "https://evilchirpy.app", want: false},
+		{origin: "https://a.chirpy.app.evil.com", want: false},
+		{origin: "http://a.chirpy.app", want: false},
+		{origin: "null", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.origin, func(t *testing.T) {
+			if got := testPolicy.allowsOrigin(tt.origin); got != tt.want {
+				t.Fatalf("allowsOrigin(%q) = %v, want %v", tt.origin, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestActualRequest(t *testing.T) {
+	tests := []struct {
+		name       string
+		policy     Policy
+		origin     string
+		wantOrigin string
+		wantCreds  string
+	}{
+		{name: "allowed origin", policy: testPolicy, origin: "https://example.com", wantOrigin: "https://example.com"},
+		{name: "other origin", policy: testPolicy, origin: "https://evil.com"},
+		{name: "no origin", policy: testPolicy},
+		{name: "any origin", policy: Policy{AllowedOrigins: []string{"*"}}, origin: "https://evil.com", wantOrigin: "*"},
+		{
+			name:       "credentials",
+			policy:     Policy{AllowedOrigins: []string{"https://example.com"}, AllowCredentials: true},
+			origin:     "https://example.com",
+			wantOrigin: "https://example.com",
+			wantCreds:  "true",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/chirps", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+
+			rec, reached := serve(tt.policy, r)
+			if !reached {
+				t.Fatal("request didn't reach the handler")
+			}
+			if got := rec.Header().Get("Vary"); got != "Origin" {
+				t.Fatalf("got Vary %q, want Origin", got)
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Fatalf("got Access-Control-Allow-Origin %q, want %q", got, tt.wantOrigin)
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Credentials"); got != tt.wantCreds {
+				t.Fatalf("got Access-Control-Allow-Credentials %q, want %q", got, tt.wantCreds)
+			}
+		})
+	}
+}
+
+func TestPreflight(t *testing.T) {
+	tests := []struct {
+		name     string
+		origin   string
+		method   string
+		headers  string
+		wantCode int
+	}{
+		{name: "allowed", origin: "https://example.com", method: "PATCH", headers: "authorization, content-type", wantCode: http.StatusNoContent},
+		{name: "allowed without headers", origin: "https://a.chirpy.app", method: "GET", wantCode: http.StatusNoContent},
+		{name: "bad origin", origin: "https://evil-example.com", method: "GET", wantCode: http.StatusForbidden},
+		{name: "bad method", origin: "https://example.com", method: "DELETE", wantCode: http.StatusForbidden},
+		{name: "bad header", origin: "https://example.com", method: "POST", headers: "Content-Type, X-Evil", wantCode: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodOptions, "/api/chirps", nil)
+			r.Header.Set("Origin", tt.origin)
+			r.Header.Set("Access-Control-Request-Method", tt.method)
+			if tt.headers != "" {
+				r.Header.Set("Access-Control-Request-Headers", tt.headers)
+			}
+
+			rec, reached := serve(testPolicy, r)
+			if reached {
+				t.Fatal("preflight reached the handler")
+			}
+			if rec.Code != tt.wantCode {
+				t.Fatalf("got status %d, want %d", rec.Code, tt.wantCode)
+			}
+			if got := rec.Header().Values("Vary"); len(got) == 0 || got[0] != "Origin" {
+				t.Fatalf("got Vary %q, want Origin first", got)
+			}
+
+			allowOrigin := rec.Header().Get("Access-Control-Allow-Origin")
+			if tt.wantCode != http.StatusNoContent {
+				if allowOrigin != "" {
+					t.Fatalf("rejected preflight got Access-Control-Allow-Origin %q", allowOrigin)
+				}
+				return
+			}
+			if allowOrigin != tt.origin {
+				t.Fatalf("got Access-Control-Allow-Origin %q, want %q", allowOrigin, tt.origin)
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Methods"); got != "GET, POST, PATCH" {
+				t.Fatalf("got Access-Control-Allow-Methods %q", got)
+			}
+		})
+	}
+}
+
+func TestWildcardOriginNeverWithCredentials(t *testing.T) {
+	p := Policy{AllowedOrigins: []string{"*"}, AllowCredentials: true}
+	if err := p.Validate(); !errors.Is(err, ErrInvalidPolicy) {
+		t.Fatalf("got error %v, want ErrInvalidPolicy", err)
+	}
+
+	// Even an unvalidated policy never sends both
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Origin", "https://evil.com")
+	rec, _ := serve(p, r)
+	if rec.Header().Get("Access-Control-Allow-Origin") == "*" && rec.Header().Get("Access-Control-Allow-Credentials") != "" {
+		t.Fatal("got a wildcard origin together with credentials")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		origins []string
+		wantErr bool
+	}{
+		{name: "exact", origins: []string{"https://example.com"}},
+		{name: "wildcard subdomain", origins: []string{"https://*.example.com"}},
+		{name: "any", origins: []string{"*"}},
+		{name: "none", origins: nil},
+		{name: "no scheme", origins: []string{"example.com"}, wantErr: true},
+		{name: "path", origins: []string{"https://example.com/app"}, wantErr: true},
+		{name: "wildcard in the middle", origins: []string{"https://a.*.example.com"}, wantErr: true},
+		{name: "other scheme", origins: []string{"ftp://example.com"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Policy{AllowedOrigins: tt.origins}.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGroups(t *testing.T) {
+	mw := Groups(Policy{AllowedOrigins: []string{"*"}, AllowedMethods: []string{"GET"}}, map[string]Policy{
+		"/api":   {AllowedOrigins: []string{"https://example.com"}, AllowedMethods: []string{"GET"}},
+		"/admin": {},
+	})
+	h := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "/api/chirps", want: "https://example.com"},
+		{path: "/api", want: "https://example.com"},
+		{path: "/apiary", want: "*"},
+		{path: "/admin/users", want: ""},
+		{path: "/app/", want: "*"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			r.Header.Set("Origin", "https://example.com")
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, r)
+			if got := rec.Header().Get("Access-Control-Allow-Origin"); got != tt.want {
+				t.Fatalf("got Access-Control-Allow-Origin %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
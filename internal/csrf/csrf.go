@@ -0,0 +1,97 @@
+// Package csrf protects browser requests with double-submit tokens.
+//
+// A random token is kept in a cookie the scripts of the site can read, and
+// every unsafe request has to send it back in a header or form field. Other
+// sites can make the browser send the cookie but can't read it, so they
+// can't send it back.
+package csrf
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net/http"
+)
+
+const (
+	// HeaderName is the header scripts send the token in
+	HeaderName = "X-CSRF-Token"
+	// FormField is the field forms send the token in
+	FormField = "csrf_token"
+)
+
+var ErrInvalidToken = errors.New("missing or invalid CSRF token")
+
+// Protection issues and checks the tokens
+type Protection struct {
+	// Secure cookies are only sent over https, they get the __Host- prefix
+	// so subdomains can't overwrite them
+	Secure bool
+}
+
+// CookieName is the name of the cookie the token is kept in
+func (p Protection) CookieName() string {
+	if p.Secure {
+		return "__Host-csrf_token"
+	}
+	return "csrf_token"
+}
+
+// Token returns the token of the browser, setting a new one if it has none
+func (p Protection) Token(w http.ResponseWriter, r *http.Request) (string, error) {
+	cookie, err := r.Cookie(p.CookieName())
+	if err == nil && len(cookie.Value) == 64 {
+		return cookie.Value, nil
+	}
+	return p.NewToken(w)
+}
+
+// NewToken sets a new token in the browser, replacing the one it had.
+// Logins need it, the old token could have been planted by someone else
+func (p Protection) NewToken(w http.ResponseWriter) (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+	http.SetCookie(w, &http.Cookie{
+		Name:   p.CookieName(),
+		Value:  token,
+		Path:   "/",
+		Secure: p.Secure,
+		// Lax keeps the cookie on links from other sites, so it isn't replaced
+		// when the user comes back to the site through one
+		SameSite: http.SameSiteLaxMode,
+	})
+	return token, nil
+}
+
+// Check returns ErrInvalidToken unless the request sends back the token
+// of its cookie in the header or the form field
+func (p Protection) Check(r *http.Request) error {
+	cookie, err := r.Cookie(p.CookieName())
+	if err != nil || cookie.Value == "" {
+		return ErrInvalidToken
+	}
+
+	sent := r.Header.Get(HeaderName)
+	if sent == "" {
+		sent = r.PostFormValue(FormField)
+	}
+	if subtle.ConstantTimeCompare([]byte(sent), []byte(cookie.Value)) != 1 {
+		return ErrInvalidToken
+	}
+	return nil
+}
+
+// IsSafeMethod reports whether the method can't change anything, so it needs no token
+func IsSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,191 @@
+package database
+
+import (
+	"errors"
+	"time"
+)
+
+// ChirpRetention decides what happens to the chirps of a deleted account
+type ChirpRetention string
+
+const (
+	// RetentionDelete deletes the chirps together with the account
+	RetentionDelete ChirpRetention = "delete"
+	// RetentionAnonymize keeps the chirps but removes their author
+	RetentionAnonymize ChirpRetention = "anonymize"
+	// RetentionKeep keeps the chirps as they are
+	RetentionKeep ChirpRetention = "keep"
+)
+
+var ErrGracePeriodOver = errors.New("account deletion grace period is over")
+
+// ParseChirpRetention validates a retention policy name
+func ParseChirpRetention(value string) (ChirpRetention, error) {
+	switch retention := ChirpRetention(value); retention {
+	case RetentionDelete, RetentionAnonymize, RetentionKeep:
+		return retention, nil
+	}
+	return "", errors.New("chirp retention must be one of delete, anonymize or keep")
+}
+
+// IsDeleted reports whether the user deleted their account
+func (u User) IsDeleted() bool {
+	return u.DeletedAt != nil
+}
+
+// DeactivateUser marks the account of the user as deleted and invalidates
+// every token issued to it so far. The account is only purged after the
+// grace period, until then logging in reactivates it
+func (db *DB) DeactivateUser(userId int, now time.Time) (User, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	structure, err := db.LoadDB()
+	if err != nil {
+		return User{}, err
+	}
+
+	user, ok := structure.Users[userId]
+	if !ok || user.IsDeleted() {
+		return User{}, ErrUserNotFound
+	}
+
+	deletedAt := now.UTC()
+	user.DeletedAt = &deletedAt
+	user.TokensValidAfter = deletedAt.Unix()
+	structure.Users[userId] = user
+	structure.revokeUserTokens(userId, now)
+
+	err = db.WriteDB(structure)
+	if err != nil {
+		return User{}, err
+	}
+
+	return user, nil
+}
+
+// ReactivateUser restores an account deleted within the grace period
+func (db *DB) ReactivateUser(userId int, gracePeriod time.Duration, now time.Time) (User, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	structure, err := db.LoadDB()
+	if err != nil {
+		return User{}, err
+	}
+
+	user, ok := structure.Users[userId]
+	if !ok {
+		return User{}, ErrUserNotFound
+	}
+	if !user.IsDeleted() {
+		return user, nil
+	}
+	if now.After(user.DeletedAt.Add(gracePeriod)) {
+		return User{}, ErrGracePeriodOver
+	}
+
+	user.DeletedAt = nil
+	structure.Users[userId] = user
+
+	err = db.WriteDB(structure)
+	if err != nil {
+		return User{}, err
+	}
+
+	return user, nil
+}
+
+// PurgeDeletedUsers removes every account deleted before the cutoff together
+// with its private data, and applies the retention policy to its chirps.
+// It returns the ids of the purged users
+func (db *DB) PurgeDeletedUsers(cutoff time.Time, retention ChirpRetention) ([]int, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	structure, err := db.LoadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	purged := make([]int, 0)
+	for userId, user := range structure.Users {
+		if !user.IsDeleted() || user.DeletedAt.After(cutoff) {
+			continue
+		}
+
+		for chirpId, chirp := range structure.Chirps {
+			if chirp.AuthorId != userId {
+				continue
+			}
+			switch retention {
+			case RetentionDelete:
+				delete(structure.Chirps, chirpId)
+				structure.removeChirpReferences(chirpId)
+			case RetentionAnonymize:
+				chirp.AuthorId = 0
+				structure.Chirps[chirpId] = chirp
+			}
+		}
+
+		delete(structure.Bookmarks, userId)
+		for collectionId, collection := range structure.Collections {
+			if collection.OwnerId == userId {
+				delete(structure.Collections, collectionId)
+			}
+		}
+		structure.removeUserRelations(userId)
+		for tokenId, token := range structure.RefreshTokens {
+			if token.UserId == userId {
+				delete(structure.RefreshTokens, tokenId)
+			}
+		}
+		for sessionId, session := range structure.Sessions {
+			if session.UserId == userId {
+				delete(structure.Sessions, sessionId)
+			}
+		}
+		for jobId, job := range structure.ExportJobs {
+			if job.UserId == userId {
+				delete(structure.ExportJobs, jobId)
+			}
+		}
+		for tokenId, token := range structure.PersonalTokens {
+			if token.UserId == userId {
+				delete(structure.PersonalTokens, tokenId)
+			}
+		}
+		for hash, reset := range structure.PasswordResets {
+			if reset.UserId == userId {
+				delete(structure.PasswordResets, hash)
+			}
+		}
+		structure.removeEmailVerifications(userId)
+		for clientId, client := range structure.OAuthClients {
+			if client.OwnerId == userId {
+				structure.removeOAuthClient(clientId, time.Now())
+			}
+		}
+		delete(structure.Users, userId)
+
+		purged = append(purged, userId)
+	}
+
+	if len(purged) == 0 {
+		return purged, nil
+	}
+
+	return purged, db.WriteDB(structure)
+}
+
+// removeUserRelations drops every block and mute from or to the user
+func (s *DBStructure) removeUserRelations(userId int) {
+	delete(s.Blocks, userId)
+	delete(s.Mutes, userId)
+	for id, blocked := range s.Blocks {
+		s.Blocks[id] = removeId(blocked, userId)
+	}
+	for id, muted := range s.Mutes {
+		s.Mutes[id] = removeId(muted, userId)
+	}
+}
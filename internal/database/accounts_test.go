@@ -0,0 +1,63 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mustafa-mun/chirpy-bootdev/internal/password"
+)
+
+func newTestDB(t *testing.T) *DB {
+	t.Helper()
+
+	password.Configure(password.Bcrypt{Cost: 4})
+	db, err := NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestPurgedUserIdIsNotReused(t *testing.T) {
+	db := newTestDB(t)
+	now := time.Now()
+
+	_, err := db.CreateUser("hunter2hunter2", "a@b.co", Profile{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	purged, err := db.CreateUser("hunter2hunter2", "c@d.co", Profile{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	chirp, err := db.CreateChirp("kept after the purge", purged.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.DeactivateUser(purged.ID, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids, err := db.PurgeDeletedUsers(now.Add(time.Minute), RetentionKeep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 1 || ids[0] != purged.ID {
+		t.Fatalf("got purged ids %v, want [%d]", ids, purged.ID)
+	}
+
+	user, err := db.CreateUser("hunter2hunter2", "e@f.co", Profile{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.ID <= purged.ID {
+		t.Fatalf("got id %d for the new user, want more than the purged id %d", user.ID, purged.ID)
+	}
+
+	err = db.DeleteChirp(chirp.ID, user.ID)
+	if err == nil {
+		t.Fatal("the new user could delete a chirp of the purged user")
+	}
+}
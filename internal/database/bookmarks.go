@@ -0,0 +1,305 @@
+package database
+
+import (
+	"errors"
+	"sort"
+	"strings"
+)
+
+type Collection struct {
+	ID       int    `json:"id"`
+	OwnerId  int    `json:"owner_id"`
+	Name     string `json:"name"`
+	ChirpIds []int  `json:"chirp_ids"`
+}
+
+var (
+	ErrChirpNotFound      = errors.New("chirp not found")
+	ErrBookmarkNotFound   = errors.New("bookmark not found")
+	ErrCollectionNotFound = errors.New("collection not found")
+	ErrCollectionExists   = errors.New("collection already exists")
+)
+
+// AddBookmark saves a chirp to the bookmarks of the user
+func (db *DB) AddBookmark(userId, chirpId int) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	structure, err := db.LoadDB()
+	if err != nil {
+		return err
+	}
+
+	chirp, ok := structure.Chirps[chirpId]
+	if !ok {
+		return ErrChirpNotFound
+	}
+	if structure.isBlocked(userId, chirp.AuthorId) {
+		return ErrBlocked
+	}
+
+	bookmarks := structure.Bookmarks[userId]
+	// Bookmarking the same chirp twice is a no-op
+	if containsId(bookmarks, chirpId) {
+		return nil
+	}
+	structure.Bookmarks[userId] = append(bookmarks, chirpId)
+
+	return db.WriteDB(structure)
+}
+
+// RemoveBookmark removes a chirp from the bookmarks of the user
+func (db *DB) RemoveBookmark(userId, chirpId int) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	structure, err := db.LoadDB()
+	if err != nil {
+		return err
+	}
+
+	bookmarks := structure.Bookmarks[userId]
+	if !containsId(bookmarks, chirpId) {
+		return ErrBookmarkNotFound
+	}
+	structure.Bookmarks[userId] = removeId(bookmarks, chirpId)
+
+	return db.WriteDB(structure)
+}
+
+// GetBookmarks returns the bookmarked chirps of the user,
+// most recently bookmarked first
+func (db *DB) GetBookmarks(userId int) ([]Chirp, error) {
+	structure, err := db.LoadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	hidden := structure.hiddenAuthors(userId, false)
+	bookmarks := structure.Bookmarks[userId]
+	chirps := make([]Chirp, 0, len(bookmarks))
+	for i := len(bookmarks) - 1; i >= 0; i-- {
+		if chirp, ok := structure.Chirps[bookmarks[i]]; ok && !hidden[chirp.AuthorId] {
+			chirps = append(chirps, chirp)
+		}
+	}
+
+	return chirps, nil
+}
+
+// CreateCollection creates a new empty private collection for the user
+func (db *DB) CreateCollection(ownerId int, name string) (Collection, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return Collection{}, errors.New("collection name is required")
+	}
+	if len(name) > 50 {
+		return Collection{}, errors.New("collection name is too long")
+	}
+
+	structure, err := db.LoadDB()
+	if err != nil {
+		return Collection{}, err
+	}
+
+	// Collection names are unique per owner
+	for _, collection := range structure.Collections {
+		if collection.OwnerId == ownerId && strings.EqualFold(collection.Name, name) {
+			return Collection{}, ErrCollectionExists
+		}
+	}
+
+	id := 1
+	for collectionId := range structure.Collections {
+		if collectionId >= id {
+			id = collectionId + 1
+		}
+	}
+
+	collection := Collection{ID: id, OwnerId: ownerId, Name: name, ChirpIds: []int{}}
+	structure.Collections[id] = collection
+
+	err = db.WriteDB(structure)
+	if err != nil {
+		return Collection{}, err
+	}
+
+	return collection, nil
+}
+
+// GetCollections returns every collection owned by the user
+func (db *DB) GetCollections(ownerId int) ([]Collection, error) {
+	structure, err := db.LoadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	collections := make([]Collection, 0)
+	for _, collection := range structure.Collections {
+		if collection.OwnerId == ownerId {
+			collections = append(collections, collection)
+		}
+	}
+
+	sort.Slice(collections, func(i, j int) bool {
+		return collections[i].ID < collections[j].ID
+	})
+
+	return collections, nil
+}
+
+// GetCollection returns a single collection of the user.
+// Collections of other users are reported as not found
+func (db *DB) GetCollection(ownerId, collectionId int) (Collection, error) {
+	structure, err := db.LoadDB()
+	if err != nil {
+		return Collection{}, err
+	}
+
+	collection, ok := structure.Collections[collectionId]
+	if !ok || collection.OwnerId != ownerId {
+		return Collection{}, ErrCollectionNotFound
+	}
+
+	return collection, nil
+}
+
+// GetCollectionChirps returns the chirps saved in a collection of the user
+func (db *DB) GetCollectionChirps(ownerId, collectionId int) ([]Chirp, error) {
+	structure, err := db.LoadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	collection, ok := structure.Collections[collectionId]
+	if !ok || collection.OwnerId != ownerId {
+		return nil, ErrCollectionNotFound
+	}
+
+	hidden := structure.hiddenAuthors(ownerId, false)
+	chirps := make([]Chirp, 0, len(collection.ChirpIds))
+	for _, chirpId := range collection.ChirpIds {
+		if chirp, ok := structure.Chirps[chirpId]; ok && !hidden[chirp.AuthorId] {
+			chirps = append(chirps, chirp)
+		}
+	}
+
+	return chirps, nil
+}
+
+// DeleteCollection deletes a collection of the user
+func (db *DB) DeleteCollection(ownerId, collectionId int) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	structure, err := db.LoadDB()
+	if err != nil {
+		return err
+	}
+
+	collection, ok := structure.Collections[collectionId]
+	if !ok || collection.OwnerId != ownerId {
+		return ErrCollectionNotFound
+	}
+	delete(structure.Collections, collectionId)
+
+	return db.WriteDB(structure)
+}
+
+// AddChirpToCollection saves a chirp to a collection of the user
+func (db *DB) AddChirpToCollection(ownerId, collectionId, chirpId int) (Collection, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	structure, err := db.LoadDB()
+	if err != nil {
+		return Collection{}, err
+	}
+
+	collection, ok := structure.Collections[collectionId]
+	if !ok || collection.OwnerId != ownerId {
+		return Collection{}, ErrCollectionNotFound
+	}
+
+	chirp, ok := structure.Chirps[chirpId]
+	if !ok {
+		return Collection{}, ErrChirpNotFound
+	}
+	if structure.isBlocked(ownerId, chirp.AuthorId) {
+		return Collection{}, ErrBlocked
+	}
+
+	if !containsId(collection.ChirpIds, chirpId) {
+		collection.ChirpIds = append(collection.ChirpIds, chirpId)
+		structure.Collections[collectionId] = collection
+	}
+
+	err = db.WriteDB(structure)
+	if err != nil {
+		return Collection{}, err
+	}
+
+	return collection, nil
+}
+
+// RemoveChirpFromCollection removes a chirp from a collection of the user
+func (db *DB) RemoveChirpFromCollection(ownerId, collectionId, chirpId int) (Collection, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	structure, err := db.LoadDB()
+	if err != nil {
+		return Collection{}, err
+	}
+
+	collection, ok := structure.Collections[collectionId]
+	if !ok || collection.OwnerId != ownerId {
+		return Collection{}, ErrCollectionNotFound
+	}
+
+	if !containsId(collection.ChirpIds, chirpId) {
+		return Collection{}, ErrChirpNotFound
+	}
+	collection.ChirpIds = removeId(collection.ChirpIds, chirpId)
+	structure.Collections[collectionId] = collection
+
+	err = db.WriteDB(structure)
+	if err != nil {
+		return Collection{}, err
+	}
+
+	return collection, nil
+}
+
+// removeChirpReferences drops a deleted chirp from every bookmark list and collection
+func (s *DBStructure) removeChirpReferences(chirpId int) {
+	for userId, bookmarks := range s.Bookmarks {
+		s.Bookmarks[userId] = removeId(bookmarks, chirpId)
+	}
+	for collectionId, collection := range s.Collections {
+		collection.ChirpIds = removeId(collection.ChirpIds, chirpId)
+		s.Collections[collectionId] = collection
+	}
+}
+
+func containsId(ids []int, id int) bool {
+	for _, value := range ids {
+		if value == id {
+			return true
+		}
+	}
+	return false
+}
+
+func removeId(ids []int, id int) []int {
+	rsp := make([]int, 0, len(ids))
+	for _, value := range ids {
+		if value != id {
+			rsp = append(rsp, value)
+		}
+	}
+	return rsp
+}
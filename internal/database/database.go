@@ -7,8 +7,10 @@ import (
 	"sort"
 	"strconv"
 	"sync"
+	"time"
 
-	"github.com/mustafa-mun/chirpy-bootdev/internal/bcrypt"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/lockout"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/password"
 )
 
 type DB struct {
@@ -19,7 +21,26 @@ type DB struct {
 type DBStructure struct {
 	Chirps map[int]Chirp `json:"chirps"`
 	Users map[int]User `json:"users"`
-	RevokedTokens map[string]string `json:"revoked_tokens"`
+	RevokedTokens map[string]RevokedToken `json:"revoked_tokens"`
+	RefreshTokens map[string]RefreshToken `json:"refresh_tokens"`
+	Sessions map[string]Session `json:"sessions"`
+	Bookmarks map[int][]int `json:"bookmarks"`
+	Collections map[int]Collection `json:"collections"`
+	Blocks map[int][]int `json:"blocks"`
+	Mutes map[int][]int `json:"mutes"`
+	ExportJobs map[int]ExportJob `json:"export_jobs"`
+	PersonalTokens map[int]PersonalToken `json:"personal_tokens"`
+	OAuthClients map[string]OAuthClient `json:"oauth_clients"`
+	AuthorizationCodes map[string]AuthorizationCode `json:"authorization_codes"`
+	PasswordResets map[string]PasswordReset `json:"password_resets"`
+	EmailVerifications map[string]EmailVerification `json:"email_verifications"`
+	LoginAttempts map[string]lockout.Attempts `json:"login_attempts"`
+	// NextUserId is the id the next user gets. Ids of purged users are
+	// never handed out again, so nothing of theirs passes to someone else
+	NextUserId int `json:"next_user_id,omitempty"`
+	// NextChirpId is the id the next chirp gets, ids of deleted chirps
+	// are never handed out again so old links can't lead to another chirp
+	NextChirpId int `json:"next_chirp_id,omitempty"`
 }
 
 type Chirp struct {
@@ -32,7 +53,21 @@ type User struct {
 	ID   int    `json:"id"`
 	Password string `json:"password"`
 	Email string `json:"email"`
+	// EmailVerified is set once the user opened the link mailed to the email
+	EmailVerified bool `json:"email_verified"`
 	IsChirpyRed bool `json:"is_chirpy_red"`
+	Handle string `json:"handle"`
+	DisplayName string `json:"display_name"`
+	Bio string `json:"bio"`
+	AvatarURL string `json:"avatar_url"`
+	// DeletedAt is set while the account waits to be purged
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// Tokens issued before this unix time are rejected
+	TokensValidAfter int64 `json:"tokens_valid_after,omitempty"`
+	Role Role `json:"role,omitempty"`
+	// SuspendedAt is set while a moderator has suspended the account
+	SuspendedAt *time.Time `json:"suspended_at,omitempty"`
+	TwoFactor *TwoFactor `json:"two_factor,omitempty"`
 }
 
 // NewDB creates a new database connection
@@ -51,10 +86,8 @@ func NewDB(path string) (*DB, error) {
 	}
 	defer f.Close()
 
-	chripMp := make(map[int]Chirp)
-	usrMp := make(map[int]User)
-	rvkMp := make(map[string]string)
-	structure := DBStructure{Chirps: chripMp, Users: usrMp, RevokedTokens: rvkMp}
+	structure := DBStructure{}
+	structure.ensureMaps()
 
 	// write structure 
 	newDb.WriteDB(structure)
@@ -62,9 +95,6 @@ func NewDB(path string) (*DB, error) {
 	return &newDb, nil
 }
 
-// This will have a more optimal solution
-var chirpIdCount int = 0
-
 // CreateChirp creates a new chirp and saves it to disk
 func (db *DB) CreateChirp(body string, authorId int) (Chirp, error) {
 	db.mux.Lock()
@@ -85,12 +115,13 @@ func (db *DB) CreateChirp(body string, authorId int) (Chirp, error) {
 		chirps = make(map[int]Chirp)
 	}
 
-	chirpIdCount += 1
-	newChirp := Chirp{ID: chirpIdCount, Body: body, AuthorId: authorId}
-	chirps[chirpIdCount] = newChirp
+	chirpId := structure.nextChirpId()
+	newChirp := Chirp{ID: chirpId, Body: body, AuthorId: authorId}
+	chirps[chirpId] = newChirp
 
-	// Update the chirpIdCount in the DBStructure
+	// Update the chirps and the id counter in the DBStructure
 	structure.Chirps = chirps
+	structure.NextChirpId = chirpId + 1
 	
 	// Write the updated data to the database file
 	db.WriteDB(structure)
@@ -99,7 +130,8 @@ func (db *DB) CreateChirp(body string, authorId int) (Chirp, error) {
 }
 
 func (db *DB) DeleteChirp(chirpId, authorId int) error {
-
+	db.mux.Lock()
+	defer db.mux.Unlock()
 
 	// Read database file
 	structure, err := db.LoadDB()
@@ -123,10 +155,11 @@ func (db *DB) DeleteChirp(chirpId, authorId int) error {
 		return errors.New("you are not the owner of this chirp")
 	}
 
-	// Delete chirp
+	// Delete chirp and every reference to it
 	delete(chirps, chirpId)
+	structure.removeChirpReferences(chirpId)
 
-	// Update the chirpIdCount in the DBStructure
+	// Update the chirps in the DBStructure
 	structure.Chirps = chirps
 	
 	// Write the updated data to the database file
@@ -134,40 +167,84 @@ func (db *DB) DeleteChirp(chirpId, authorId int) error {
 
 	return nil
 }
-var userIdCount = 0
-
-// CreateChirp creates a new chirp and saves it to disk
-func (db *DB) CreateUser(password, email string) (User, error) {
+// CreateUser creates a new user with the given public profile and saves it to disk
+func (db *DB) CreateUser(password, email string, profile Profile) (User, error) {
 	db.mux.Lock()
 	defer db.mux.Unlock()
 
-	userIdCount += 1
-
-	createdUser, err := db.handleUserCreation(password, email, userIdCount)
-
+	structure, err := db.LoadDB()
 	if err != nil {
-		// revoke userId increase
-		userIdCount -= 1
 		return User{}, err
 	}
-	return createdUser, nil
+
+	return db.handleUserCreation(password, email, structure.nextUserId(), profile)
+}
+
+// nextChirpId is the id of the next chirp. Files written before the counter
+// existed continue after their biggest stored id
+func (structure DBStructure) nextChirpId() int {
+	next := structure.NextChirpId
+	for id := range structure.Chirps {
+		if id >= next {
+			next = id + 1
+		}
+	}
+	if next < 1 {
+		next = 1
+	}
+	return next
+}
+
+// nextUserId is the id of the next user. Files written before the counter
+// existed continue after their biggest stored id
+func (structure DBStructure) nextUserId() int {
+	next := structure.NextUserId
+	for id := range structure.Users {
+		if id >= next {
+			next = id + 1
+		}
+	}
+	if next < 1 {
+		next = 1
+	}
+	return next
 }
 
+// UpdateUser replaces the email and password of the user,
+// every other field of the user is kept as it is
 func (db *DB) UpdateUser(email, password string, userId int) (User, error) {
-	db.mux.Lock()
-	defer db.mux.Unlock()
+	return db.PatchUser(userId, UserPatch{Email: &email, Password: &password})
+}
 
-	updatedUser, err := db.handleUserCreation(password, email, userId)
+func (db *DB) handleUserCreation(plainPassword, email string, id int, profile Profile) (User, error) {
+	if plainPassword == "" {
+		return User{}, ErrPasswordRequired
+	}
+	err := password.Validate(plainPassword)
+	if err != nil {
+		return User{}, err
+	}
 
+	email, err = NormalizeEmail(email)
 	if err != nil {
 		return User{}, err
 	}
-	return updatedUser, nil
-}
 
-func (db *DB) handleUserCreation(password, email string, id int) (User, error) {
 	// check if user is already exists
-	err := db.checkDuplicateUser(email)
+	err = db.checkDuplicateUser(email, id)
+	if err != nil {
+		return User{}, err
+	}
+
+	// users without a handle get a generated one
+	if profile.Handle == "" {
+		profile.Handle = "user" + strconv.Itoa(id)
+	}
+	profile, err = profile.normalize()
+	if err != nil {
+		return User{}, err
+	}
+	err = db.checkDuplicateHandle(profile.Handle, id)
 	if err != nil {
 		return User{}, err
 	}
@@ -182,17 +259,19 @@ func (db *DB) handleUserCreation(password, email string, id int) (User, error) {
 	// Access the Users map
 	users := structure.Users
 
-	hashedPassword, err := bcrypt.CreateHashedPassword(password)
+	hashedPassword, err := password.Hash(plainPassword)
 
 	if err != nil {
 		return User{}, err
 	}
 
 	user := User{ID: id, Password: hashedPassword, Email: email, IsChirpyRed: false}
+	user.setProfile(profile)
 	users[id] = user
 
-	// Update the idCount in the DBStructure
+	// Update the users and the id counter in the DBStructure
 	structure.Users = users
+	structure.NextUserId = id + 1
 	
 	// Write the updated data to the database file
 	db.WriteDB(structure)
@@ -201,7 +280,8 @@ func (db *DB) handleUserCreation(password, email string, id int) (User, error) {
 }
 
 
-func (db *DB) checkDuplicateUser(email string) error {
+// checkDuplicateUser checks if the email is used by any user other than id
+func (db *DB) checkDuplicateUser(email string, id int) error {
 	// Read database file
 	structure, err := db.LoadDB()
 	if err != nil {
@@ -211,16 +291,19 @@ func (db *DB) checkDuplicateUser(email string) error {
 	users := structure.Users
 
 	for _, user := range users {
-		if user.Email == email {
-			return errors.New("user already exists")
+		if user.ID != id && EmailsEqual(user.Email, email) {
+			return ErrEmailTaken
 		}
 	}
 
 	return nil
 }
 
-// GetChirps returns all chirps in the database
-func (db *DB) GetChirps(authorQuery, sortQuery string) ([]Chirp, error) {
+// GetChirps returns all chirps in the database that are visible to the viewer.
+// Chirps of blocked users are always hidden, chirps of muted users are only
+// hidden from the timeline and still returned when they are asked for by author.
+// A viewerId of 0 means an anonymous viewer
+func (db *DB) GetChirps(authorQuery, sortQuery string, viewerId int) ([]Chirp, error) {
 	// Read database file
 	structure, err := db.LoadDB()
 	if err != nil {
@@ -237,8 +320,9 @@ func (db *DB) GetChirps(authorQuery, sortQuery string) ([]Chirp, error) {
 		if err != nil {
 			return nil, err
 		}
+		hidden := structure.hiddenAuthors(viewerId, false)
 		for _, value := range chirps {
-			if value.AuthorId == authorId {
+			if value.AuthorId == authorId && !hidden[value.AuthorId] {
 				rsp = append(rsp, value)
 			}
 		}
@@ -257,10 +341,13 @@ func (db *DB) GetChirps(authorQuery, sortQuery string) ([]Chirp, error) {
 		return rsp, nil
 	}
 
+	hidden := structure.hiddenAuthors(viewerId, true)
 	chirpsArray := make([]Chirp, 0, len(chirps))
 
 	for _, value := range chirps {
-		chirpsArray = append(chirpsArray, value)
+		if !hidden[value.AuthorId] {
+			chirpsArray = append(chirpsArray, value)
+		}
 	}
 
 	sort.Slice(chirpsArray, func(i, j int) bool {
@@ -273,10 +360,26 @@ func (db *DB) GetChirps(authorQuery, sortQuery string) ([]Chirp, error) {
 	return chirpsArray, nil
 }
 
+// GetChirp returns the chirp if the viewer may see it. Chirps of deleted
+// accounts and of users blocked either way are not found.
+// A viewerId of 0 means an anonymous viewer
+func (db *DB) GetChirp(chirpId, viewerId int) (Chirp, error) {
+	structure, err := db.LoadDB()
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	chirp, ok := structure.Chirps[chirpId]
+	if !ok || structure.hiddenAuthors(viewerId, false)[chirp.AuthorId] {
+		return Chirp{}, ErrChirpNotFound
+	}
+	return chirp, nil
+}
+
 // loadDB reads the database file into memory
 func (db *DB) LoadDB() (DBStructure, error) {
 	// Read database file
-	data, err := os.ReadFile("database.json")
+	data, err := os.ReadFile(db.path)
 	if err != nil {
 		return DBStructure{}, err
 	}
@@ -288,9 +391,64 @@ func (db *DB) LoadDB() (DBStructure, error) {
 		return DBStructure{}, err
 	}
 
+	// Database files written by older versions may miss some maps
+	structure.ensureMaps()
+
 	return structure, nil
 }
 
+// ensureMaps initializes every nil map of the structure
+func (s *DBStructure) ensureMaps() {
+	if s.Chirps == nil {
+		s.Chirps = make(map[int]Chirp)
+	}
+	if s.Users == nil {
+		s.Users = make(map[int]User)
+	}
+	if s.RevokedTokens == nil {
+		s.RevokedTokens = make(map[string]RevokedToken)
+	}
+	if s.RefreshTokens == nil {
+		s.RefreshTokens = make(map[string]RefreshToken)
+	}
+	if s.Sessions == nil {
+		s.Sessions = make(map[string]Session)
+	}
+	if s.Bookmarks == nil {
+		s.Bookmarks = make(map[int][]int)
+	}
+	if s.Collections == nil {
+		s.Collections = make(map[int]Collection)
+	}
+	if s.Blocks == nil {
+		s.Blocks = make(map[int][]int)
+	}
+	if s.Mutes == nil {
+		s.Mutes = make(map[int][]int)
+	}
+	if s.ExportJobs == nil {
+		s.ExportJobs = make(map[int]ExportJob)
+	}
+	if s.PersonalTokens == nil {
+		s.PersonalTokens = make(map[int]PersonalToken)
+	}
+	if s.OAuthClients == nil {
+		s.OAuthClients = make(map[string]OAuthClient)
+	}
+	if s.AuthorizationCodes == nil {
+		s.AuthorizationCodes = make(map[string]AuthorizationCode)
+	}
+	if s.PasswordResets == nil {
+		s.PasswordResets = make(map[string]PasswordReset)
+	}
+	if s.EmailVerifications == nil {
+		s.EmailVerifications = make(map[string]EmailVerification)
+	}
+	if s.LoginAttempts == nil {
+		s.LoginAttempts = make(map[string]lockout.Attempts)
+	}
+}
+
 // writeDB writes the database file to disk
 func (db *DB) WriteDB(dbStructure DBStructure) error  {
 	data, err := json.Marshal(dbStructure)
@@ -298,7 +456,7 @@ func (db *DB) WriteDB(dbStructure DBStructure) error  {
 		return errors.New("an error occurred when encoding database structure to JSON")
 	}
 
-	err = os.WriteFile("database.json", data, 0644)
+	err = os.WriteFile(db.path, data, 0644)
 	if err != nil {
 		return errors.New("an error occurred when writing data to the database file")
 	}
@@ -0,0 +1,68 @@
+package database
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDeletedChirpIdIsNotReused(t *testing.T) {
+	db := newTestDB(t)
+
+	user, err := db.CreateUser("hunter2hunter2", "a@b.co", Profile{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deleted, err := db.CreateChirp("deleted", user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.DeleteChirp(deleted.ID, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A restart must not start over either
+	db, err = NewDB(db.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chirp, err := db.CreateChirp("new", user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chirp.ID <= deleted.ID {
+		t.Fatalf("got id %d for the new chirp, want more than the deleted id %d", chirp.ID, deleted.ID)
+	}
+}
+
+func TestGetChirpHidesDeletedAuthors(t *testing.T) {
+	db := newTestDB(t)
+
+	user, err := db.CreateUser("hunter2hunter2", "a@b.co", Profile{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	chirp, err := db.CreateChirp("hello", user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.DeactivateUser(user.ID, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.GetChirp(chirp.ID, 0)
+	if !errors.Is(err, ErrChirpNotFound) {
+		t.Fatalf("got error %v for a chirp of a deleted account, want ErrChirpNotFound", err)
+	}
+
+	_, err = db.ReactivateUser(user.ID, time.Hour, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.GetChirp(chirp.ID, 0)
+	if err != nil {
+		t.Fatalf("got error %v after the reactivation, want none", err)
+	}
+}
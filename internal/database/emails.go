@@ -0,0 +1,106 @@
+package database
+
+import (
+	"errors"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// EmailVerification is a token mailed to the user to prove the email is theirs.
+// It is stored by the hash of the token
+type EmailVerification struct {
+	Hash   string `json:"hash"`
+	UserId int    `json:"user_id"`
+	// Email is the address the token was sent to, changing the email
+	// of the user makes the token useless
+	Email     string    `json:"email"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+var (
+	ErrInvalidEmail        = errors.New("email is not a valid email address")
+	ErrVerificationInvalid = errors.New("email verification token is invalid or expired")
+)
+
+// NormalizeEmail validates the email and returns it in its stored form.
+// Emails are case insensitive and stored in lower case
+func NormalizeEmail(email string) (string, error) {
+	email = strings.TrimSpace(email)
+	if email == "" {
+		return "", ErrEmailRequired
+	}
+	if len(email) > 254 {
+		return "", ErrInvalidEmail
+	}
+
+	// Only a bare address is accepted, not "Name <address>"
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Name != "" || addr.Address != email {
+		return "", ErrInvalidEmail
+	}
+	domain := email[strings.LastIndex(email, "@")+1:]
+	if !strings.Contains(domain, ".") || strings.HasPrefix(domain, "[") {
+		return "", ErrInvalidEmail
+	}
+
+	return strings.ToLower(email), nil
+}
+
+// EmailsEqual reports whether both are the same email. Emails stored before
+// they were normalized may differ in case
+func EmailsEqual(a, b string) bool {
+	return strings.EqualFold(strings.TrimSpace(a), strings.TrimSpace(b))
+}
+
+// CreateEmailVerification stores a new verification token of the user.
+// Tokens the user was sent before stop working
+func (db *DB) CreateEmailVerification(verification EmailVerification) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	structure, err := db.LoadDB()
+	if err != nil {
+		return err
+	}
+
+	structure.removeEmailVerifications(verification.UserId)
+	structure.EmailVerifications[verification.Hash] = verification
+
+	return db.WriteDB(structure)
+}
+
+// VerifyEmail marks the email the token was sent to as verified
+func (db *DB) VerifyEmail(hash string, now time.Time) (User, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	structure, err := db.LoadDB()
+	if err != nil {
+		return User{}, err
+	}
+
+	verification, ok := structure.EmailVerifications[hash]
+	if !ok || !verification.ExpiresAt.After(now) {
+		return User{}, ErrVerificationInvalid
+	}
+	user, ok := structure.Users[verification.UserId]
+	if !ok || user.IsDeleted() || user.Email != verification.Email {
+		return User{}, ErrVerificationInvalid
+	}
+
+	user.EmailVerified = true
+	structure.Users[user.ID] = user
+	delete(structure.EmailVerifications, hash)
+
+	return user, db.WriteDB(structure)
+}
+
+// removeEmailVerifications deletes every verification token of the user
+func (s *DBStructure) removeEmailVerifications(userId int) {
+	for hash, verification := range s.EmailVerifications {
+		if verification.UserId == userId {
+			delete(s.EmailVerifications, hash)
+		}
+	}
+}
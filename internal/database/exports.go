@@ -0,0 +1,141 @@
+package database
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// Statuses of an export job
+const (
+	ExportPending  = "pending"
+	ExportRunning  = "running"
+	ExportComplete = "complete"
+	ExportFailed   = "failed"
+)
+
+type ExportJob struct {
+	ID          int        `json:"id"`
+	UserId      int        `json:"user_id"`
+	Status      string     `json:"status"`
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	// Path of the finished zip file on disk
+	Path  string `json:"path,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// UserData is everything stored about a single user
+type UserData struct {
+	User        User
+	Chirps      []Chirp
+	Bookmarks   []Chirp
+	Collections []Collection
+	Blocks      []int
+	Mutes       []int
+}
+
+var ErrExportNotFound = errors.New("export not found")
+
+// CreateExportJob creates a new pending export job for the user
+func (db *DB) CreateExportJob(userId int, now time.Time) (ExportJob, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	structure, err := db.LoadDB()
+	if err != nil {
+		return ExportJob{}, err
+	}
+
+	id := 1
+	for jobId := range structure.ExportJobs {
+		if jobId >= id {
+			id = jobId + 1
+		}
+	}
+
+	job := ExportJob{ID: id, UserId: userId, Status: ExportPending, CreatedAt: now.UTC()}
+	structure.ExportJobs[id] = job
+
+	err = db.WriteDB(structure)
+	if err != nil {
+		return ExportJob{}, err
+	}
+
+	return job, nil
+}
+
+// SaveExportJob stores the new state of an export job
+func (db *DB) SaveExportJob(job ExportJob) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	structure, err := db.LoadDB()
+	if err != nil {
+		return err
+	}
+
+	if _, ok := structure.ExportJobs[job.ID]; !ok {
+		return ErrExportNotFound
+	}
+	structure.ExportJobs[job.ID] = job
+
+	return db.WriteDB(structure)
+}
+
+// GetExportJob returns an export job by id
+func (db *DB) GetExportJob(jobId int) (ExportJob, error) {
+	structure, err := db.LoadDB()
+	if err != nil {
+		return ExportJob{}, err
+	}
+
+	job, ok := structure.ExportJobs[jobId]
+	if !ok {
+		return ExportJob{}, ErrExportNotFound
+	}
+
+	return job, nil
+}
+
+// GetUserData collects everything stored about the user
+func (db *DB) GetUserData(userId int) (UserData, error) {
+	user, err := db.GetUser(userId)
+	if err != nil {
+		return UserData{}, err
+	}
+
+	structure, err := db.LoadDB()
+	if err != nil {
+		return UserData{}, err
+	}
+
+	chirps := make([]Chirp, 0)
+	for _, chirp := range structure.Chirps {
+		if chirp.AuthorId == userId {
+			chirps = append(chirps, chirp)
+		}
+	}
+	sort.Slice(chirps, func(i, j int) bool {
+		return chirps[i].ID < chirps[j].ID
+	})
+
+	bookmarks, err := db.GetBookmarks(userId)
+	if err != nil {
+		return UserData{}, err
+	}
+
+	collections, err := db.GetCollections(userId)
+	if err != nil {
+		return UserData{}, err
+	}
+
+	return UserData{
+		User:        user,
+		Chirps:      chirps,
+		Bookmarks:   bookmarks,
+		Collections: collections,
+		Blocks:      sortedIds(structure.Blocks[userId]),
+		Mutes:       sortedIds(structure.Mutes[userId]),
+	}, nil
+}
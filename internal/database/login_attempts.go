@@ -0,0 +1,66 @@
+package database
+
+import (
+	"strings"
+	"time"
+
+	"github.com/mustafa-mun/chirpy-bootdev/internal/lockout"
+)
+
+// Failed logins are kept by normalized email instead of by user, so emails
+// without an account are slowed down and locked exactly like real ones
+
+// GetLoginAttempts returns the failed logins of the email
+func (db *DB) GetLoginAttempts(email string) (lockout.Attempts, error) {
+	structure, err := db.LoadDB()
+	if err != nil {
+		return lockout.Attempts{}, err
+	}
+
+	return structure.LoginAttempts[loginAttemptsKey(email)], nil
+}
+
+// RecordLoginFailure counts a failed login of the email
+func (db *DB) RecordLoginFailure(email string, policy lockout.Policy, now time.Time) (lockout.Attempts, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	structure, err := db.LoadDB()
+	if err != nil {
+		return lockout.Attempts{}, err
+	}
+
+	key := loginAttemptsKey(email)
+	attempts := policy.Fail(structure.LoginAttempts[key], now)
+	structure.LoginAttempts[key] = attempts
+
+	return attempts, db.WriteDB(structure)
+}
+
+// ResetLoginAttempts forgets the failed logins of the email, which unlocks it
+func (db *DB) ResetLoginAttempts(email string) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	structure, err := db.LoadDB()
+	if err != nil {
+		return err
+	}
+
+	key := loginAttemptsKey(email)
+	if _, ok := structure.LoginAttempts[key]; !ok {
+		return nil
+	}
+	delete(structure.LoginAttempts, key)
+
+	return db.WriteDB(structure)
+}
+
+func loginAttemptsKey(email string) string {
+	normalized, err := NormalizeEmail(email)
+	if err != nil {
+		// invalid emails are still counted, in lower case like valid ones
+		return strings.ToLower(strings.TrimSpace(email))
+	}
+	return normalized
+}
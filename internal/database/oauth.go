@@ -0,0 +1,207 @@
+package database
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// OAuthClient is a third party app which acts on behalf of Chirpy users
+type OAuthClient struct {
+	ID      string `json:"id"`
+	OwnerId int    `json:"owner_id"`
+	Name    string `json:"name"`
+	// SecretHash is empty for public clients, which can't keep a secret
+	SecretHash   string    `json:"secret_hash,omitempty"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// AuthorizationCode is a single use code the client exchanges for tokens.
+// It is stored by the hash of the code
+type AuthorizationCode struct {
+	Hash          string    `json:"hash"`
+	ClientId      string    `json:"client_id"`
+	UserId        int       `json:"user_id"`
+	RedirectURI   string    `json:"redirect_uri"`
+	Scopes        []string  `json:"scopes"`
+	CodeChallenge string    `json:"code_challenge"`
+	ExpiresAt     time.Time `json:"expires_at"`
+	// SessionId is the session the code was exchanged for
+	SessionId string     `json:"session_id,omitempty"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+}
+
+var (
+	ErrClientNotFound = errors.New("oauth client not found")
+	ErrCodeNotFound   = errors.New("authorization code not found")
+	ErrCodeReused     = errors.New("authorization code has already been used")
+)
+
+// IsConfidential reports whether the client authenticates with a secret
+func (c OAuthClient) IsConfidential() bool {
+	return c.SecretHash != ""
+}
+
+// HasRedirectURI reports whether the uri is registered for the client
+func (c OAuthClient) HasRedirectURI(uri string) bool {
+	for _, registered := range c.RedirectURIs {
+		if registered == uri {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateOAuthClient registers a new OAuth client
+func (db *DB) CreateOAuthClient(client OAuthClient) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	structure, err := db.LoadDB()
+	if err != nil {
+		return err
+	}
+
+	structure.OAuthClients[client.ID] = client
+
+	return db.WriteDB(structure)
+}
+
+// GetOAuthClient returns a client by id
+func (db *DB) GetOAuthClient(clientId string) (OAuthClient, error) {
+	structure, err := db.LoadDB()
+	if err != nil {
+		return OAuthClient{}, err
+	}
+
+	client, ok := structure.OAuthClients[clientId]
+	if !ok {
+		return OAuthClient{}, ErrClientNotFound
+	}
+
+	return client, nil
+}
+
+// GetUserOAuthClients returns the clients the user registered, oldest first
+func (db *DB) GetUserOAuthClients(ownerId int) ([]OAuthClient, error) {
+	structure, err := db.LoadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	clients := make([]OAuthClient, 0)
+	for _, client := range structure.OAuthClients {
+		if client.OwnerId == ownerId {
+			clients = append(clients, client)
+		}
+	}
+
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].CreatedAt.Before(clients[j].CreatedAt)
+	})
+
+	return clients, nil
+}
+
+// DeleteOAuthClient deletes a client of the user. Every session
+// granted to the client is revoked together with it
+func (db *DB) DeleteOAuthClient(ownerId int, clientId string, now time.Time) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	structure, err := db.LoadDB()
+	if err != nil {
+		return err
+	}
+
+	client, ok := structure.OAuthClients[clientId]
+	if !ok || client.OwnerId != ownerId {
+		return ErrClientNotFound
+	}
+	structure.removeOAuthClient(clientId, now)
+
+	return db.WriteDB(structure)
+}
+
+// CreateAuthorizationCode stores a newly issued authorization code
+func (db *DB) CreateAuthorizationCode(code AuthorizationCode) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	structure, err := db.LoadDB()
+	if err != nil {
+		return err
+	}
+
+	structure.AuthorizationCodes[code.Hash] = code
+
+	return db.WriteDB(structure)
+}
+
+// GetAuthorizationCode returns the unexpired authorization code with the hash
+func (db *DB) GetAuthorizationCode(hash string, now time.Time) (AuthorizationCode, error) {
+	structure, err := db.LoadDB()
+	if err != nil {
+		return AuthorizationCode{}, err
+	}
+
+	code, ok := structure.AuthorizationCodes[hash]
+	if !ok || !code.ExpiresAt.After(now) {
+		return AuthorizationCode{}, ErrCodeNotFound
+	}
+
+	return code, nil
+}
+
+// RedeemAuthorizationCode exchanges the code for the session and its first
+// refresh token. A code can only be redeemed once, redeeming it again means
+// it was stolen, so the session it was exchanged for is revoked
+func (db *DB) RedeemAuthorizationCode(hash string, session Session, token RefreshToken, now time.Time) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	structure, err := db.LoadDB()
+	if err != nil {
+		return err
+	}
+
+	code, ok := structure.AuthorizationCodes[hash]
+	if !ok || !code.ExpiresAt.After(now) {
+		return ErrCodeNotFound
+	}
+	if code.UsedAt != nil {
+		structure.revokeTokenFamily(code.SessionId, now)
+		err = db.WriteDB(structure)
+		if err != nil {
+			return err
+		}
+		return ErrCodeReused
+	}
+
+	usedAt := now.UTC()
+	code.UsedAt = &usedAt
+	code.SessionId = session.ID
+	structure.AuthorizationCodes[hash] = code
+
+	token.FamilyId = session.ID
+	structure.Sessions[session.ID] = session
+	structure.RefreshTokens[token.ID] = token
+
+	return db.WriteDB(structure)
+}
+
+// removeOAuthClient deletes the client with its codes and revokes its sessions
+func (s *DBStructure) removeOAuthClient(clientId string, now time.Time) {
+	delete(s.OAuthClients, clientId)
+	for hash, code := range s.AuthorizationCodes {
+		if code.ClientId == clientId {
+			delete(s.AuthorizationCodes, hash)
+		}
+	}
+	for id, session := range s.Sessions {
+		if session.ClientId == clientId {
+			s.revokeTokenFamily(id, now)
+		}
+	}
+}
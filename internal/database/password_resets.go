@@ -0,0 +1,86 @@
+package database
+
+import (
+	"errors"
+	"time"
+
+	"github.com/mustafa-mun/chirpy-bootdev/internal/password"
+)
+
+// PasswordReset is a single use token which lets a user set a new password.
+// It is stored by the hash of the token
+type PasswordReset struct {
+	Hash      string     `json:"hash"`
+	UserId    int        `json:"user_id"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+}
+
+var ErrResetTokenInvalid = errors.New("password reset token is invalid or expired")
+
+// CreatePasswordReset stores a new reset token of the user.
+// Reset tokens the user requested before stop working
+func (db *DB) CreatePasswordReset(reset PasswordReset) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	structure, err := db.LoadDB()
+	if err != nil {
+		return err
+	}
+
+	for hash, other := range structure.PasswordResets {
+		if other.UserId == reset.UserId {
+			delete(structure.PasswordResets, hash)
+		}
+	}
+	structure.PasswordResets[reset.Hash] = reset
+
+	return db.WriteDB(structure)
+}
+
+// ResetPassword sets the new password of the user the reset token belongs to.
+// The token is used up and every session of the user is logged out
+func (db *DB) ResetPassword(hash, newPassword string, now time.Time) (User, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	structure, err := db.LoadDB()
+	if err != nil {
+		return User{}, err
+	}
+
+	reset, ok := structure.PasswordResets[hash]
+	if !ok || reset.UsedAt != nil || !reset.ExpiresAt.After(now) {
+		return User{}, ErrResetTokenInvalid
+	}
+	user, ok := structure.Users[reset.UserId]
+	if !ok || user.IsDeleted() {
+		return User{}, ErrResetTokenInvalid
+	}
+
+	if newPassword == "" {
+		return User{}, ErrPasswordRequired
+	}
+	err = password.Validate(newPassword)
+	if err != nil {
+		return User{}, err
+	}
+	hashedPassword, err := password.Hash(newPassword)
+	if err != nil {
+		return User{}, err
+	}
+
+	usedAt := now.UTC()
+	reset.UsedAt = &usedAt
+	structure.PasswordResets[hash] = reset
+
+	user.Password = hashedPassword
+	user.TokensValidAfter = usedAt.Unix()
+	structure.Users[user.ID] = user
+	structure.revokeUserTokens(user.ID, now)
+	// the user proved they own the email, so it isn't locked out anymore
+	delete(structure.LoginAttempts, loginAttemptsKey(user.Email))
+
+	return user, db.WriteDB(structure)
+}
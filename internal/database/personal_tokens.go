@@ -0,0 +1,149 @@
+package database
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// PersonalToken is a long lived token a user created for scripts and bots.
+// Only the hash of the token is stored, the token itself is shown once
+type PersonalToken struct {
+	ID     int    `json:"id"`
+	UserId int    `json:"user_id"`
+	Name   string `json:"name"`
+	Hash   string `json:"hash"`
+	// Hint is the start of the token, so users can tell their tokens apart
+	Hint       string     `json:"hint"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+var ErrPersonalTokenNotFound = errors.New("personal access token not found")
+
+// lastUsedPrecision is how stale the last used time of a token may get,
+// so every request with a token doesn't rewrite the database
+const lastUsedPrecision = time.Minute
+
+// IsActive reports whether the token can still be used
+func (t PersonalToken) IsActive(now time.Time) bool {
+	return t.RevokedAt == nil && (t.ExpiresAt == nil || t.ExpiresAt.After(now))
+}
+
+// CreatePersonalToken stores a new personal access token
+func (db *DB) CreatePersonalToken(token PersonalToken) (PersonalToken, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	structure, err := db.LoadDB()
+	if err != nil {
+		return PersonalToken{}, err
+	}
+
+	id := 1
+	for tokenId := range structure.PersonalTokens {
+		if tokenId >= id {
+			id = tokenId + 1
+		}
+	}
+
+	token.ID = id
+	structure.PersonalTokens[id] = token
+
+	err = db.WriteDB(structure)
+	if err != nil {
+		return PersonalToken{}, err
+	}
+
+	return token, nil
+}
+
+// GetPersonalTokens returns the active personal access tokens of the user, newest first
+func (db *DB) GetPersonalTokens(userId int, now time.Time) ([]PersonalToken, error) {
+	structure, err := db.LoadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := make([]PersonalToken, 0)
+	for _, token := range structure.PersonalTokens {
+		if token.UserId == userId && token.IsActive(now) {
+			tokens = append(tokens, token)
+		}
+	}
+
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].ID > tokens[j].ID
+	})
+
+	return tokens, nil
+}
+
+// UsePersonalToken finds the active token with the hash and records that it was used
+func (db *DB) UsePersonalToken(hash string, now time.Time) (PersonalToken, error) {
+	structure, err := db.LoadDB()
+	if err != nil {
+		return PersonalToken{}, err
+	}
+
+	token, ok := findPersonalToken(structure, hash)
+	if !ok || !token.IsActive(now) {
+		return PersonalToken{}, ErrPersonalTokenNotFound
+	}
+	if token.LastUsedAt != nil && now.Sub(*token.LastUsedAt) < lastUsedPrecision {
+		return token, nil
+	}
+
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	structure, err = db.LoadDB()
+	if err != nil {
+		return PersonalToken{}, err
+	}
+	// The token could have been revoked since the first read
+	token, ok = findPersonalToken(structure, hash)
+	if !ok || !token.IsActive(now) {
+		return PersonalToken{}, ErrPersonalTokenNotFound
+	}
+
+	lastUsedAt := now.UTC()
+	token.LastUsedAt = &lastUsedAt
+	structure.PersonalTokens[token.ID] = token
+
+	return token, db.WriteDB(structure)
+}
+
+// RevokePersonalToken revokes a personal access token of the user
+func (db *DB) RevokePersonalToken(userId, tokenId int, now time.Time) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	structure, err := db.LoadDB()
+	if err != nil {
+		return err
+	}
+
+	token, ok := structure.PersonalTokens[tokenId]
+	if !ok || token.UserId != userId || !token.IsActive(now) {
+		return ErrPersonalTokenNotFound
+	}
+
+	revokedAt := now.UTC()
+	token.RevokedAt = &revokedAt
+	structure.PersonalTokens[tokenId] = token
+
+	return db.WriteDB(structure)
+}
+
+func findPersonalToken(structure DBStructure, hash string) (PersonalToken, bool) {
+	for _, token := range structure.PersonalTokens {
+		if token.Hash == hash {
+			return token, true
+		}
+	}
+	return PersonalToken{}, false
+}
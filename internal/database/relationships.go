@@ -0,0 +1,152 @@
+package database
+
+import (
+	"errors"
+	"sort"
+)
+
+var (
+	ErrBlocked      = errors.New("you can't interact with this user")
+	ErrSelfRelation = errors.New("you can't block or mute yourself")
+	ErrNoRelation   = errors.New("relationship not found")
+)
+
+// BlockUser blocks the target user for the user.
+// Blocking hides the chirps of both users from each other
+func (db *DB) BlockUser(userId, targetId int) error {
+	return db.addRelation(userId, targetId, func(s *DBStructure) map[int][]int { return s.Blocks })
+}
+
+// UnblockUser removes a block created by the user
+func (db *DB) UnblockUser(userId, targetId int) error {
+	return db.removeRelation(userId, targetId, func(s *DBStructure) map[int][]int { return s.Blocks })
+}
+
+// MuteUser mutes the target user for the user.
+// Muting only hides the target's chirps from the user's own timeline
+func (db *DB) MuteUser(userId, targetId int) error {
+	return db.addRelation(userId, targetId, func(s *DBStructure) map[int][]int { return s.Mutes })
+}
+
+// UnmuteUser removes a mute created by the user
+func (db *DB) UnmuteUser(userId, targetId int) error {
+	return db.removeRelation(userId, targetId, func(s *DBStructure) map[int][]int { return s.Mutes })
+}
+
+// GetBlockedUsers returns the ids of the users blocked by the user
+func (db *DB) GetBlockedUsers(userId int) ([]int, error) {
+	structure, err := db.LoadDB()
+	if err != nil {
+		return nil, err
+	}
+	return sortedIds(structure.Blocks[userId]), nil
+}
+
+// GetMutedUsers returns the ids of the users muted by the user
+func (db *DB) GetMutedUsers(userId int) ([]int, error) {
+	structure, err := db.LoadDB()
+	if err != nil {
+		return nil, err
+	}
+	return sortedIds(structure.Mutes[userId]), nil
+}
+
+// IsBlocked reports whether either user has blocked the other
+func (db *DB) IsBlocked(userId, otherId int) (bool, error) {
+	structure, err := db.LoadDB()
+	if err != nil {
+		return false, err
+	}
+	return structure.isBlocked(userId, otherId), nil
+}
+
+func (db *DB) addRelation(userId, targetId int, relations func(*DBStructure) map[int][]int) error {
+	if userId == targetId {
+		return ErrSelfRelation
+	}
+
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	structure, err := db.LoadDB()
+	if err != nil {
+		return err
+	}
+
+	if _, ok := structure.Users[targetId]; !ok {
+		return ErrUserNotFound
+	}
+
+	mp := relations(&structure)
+	if !containsId(mp[userId], targetId) {
+		mp[userId] = append(mp[userId], targetId)
+	}
+
+	return db.WriteDB(structure)
+}
+
+func (db *DB) removeRelation(userId, targetId int, relations func(*DBStructure) map[int][]int) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	structure, err := db.LoadDB()
+	if err != nil {
+		return err
+	}
+
+	mp := relations(&structure)
+	if !containsId(mp[userId], targetId) {
+		return ErrNoRelation
+	}
+	mp[userId] = removeId(mp[userId], targetId)
+
+	return db.WriteDB(structure)
+}
+
+// isBlocked reports whether either user has blocked the other
+func (s *DBStructure) isBlocked(userId, otherId int) bool {
+	if userId == 0 || otherId == 0 {
+		return false
+	}
+	return containsId(s.Blocks[userId], otherId) || containsId(s.Blocks[otherId], userId)
+}
+
+// hiddenAuthors returns the authors whose chirps the viewer must not see.
+// A viewerId of 0 means an anonymous viewer.
+// Muted authors are only included when includeMuted is set
+func (s *DBStructure) hiddenAuthors(viewerId int, includeMuted bool) map[int]bool {
+	hidden := make(map[int]bool)
+
+	// Chirps of deleted accounts are hidden from everyone
+	for id, user := range s.Users {
+		if user.IsDeleted() {
+			hidden[id] = true
+		}
+	}
+
+	if viewerId == 0 {
+		return hidden
+	}
+
+	for _, id := range s.Blocks[viewerId] {
+		hidden[id] = true
+	}
+	for blockerId, blocked := range s.Blocks {
+		if containsId(blocked, viewerId) {
+			hidden[blockerId] = true
+		}
+	}
+	if includeMuted {
+		for _, id := range s.Mutes[viewerId] {
+			hidden[id] = true
+		}
+	}
+
+	return hidden
+}
+
+func sortedIds(ids []int) []int {
+	rsp := append(make([]int, 0, len(ids)), ids...)
+	sort.Ints(rsp)
+	return rsp
+}
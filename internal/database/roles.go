@@ -0,0 +1,233 @@
+package database
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// Role decides what a user is allowed to do besides using their own account
+type Role string
+
+const (
+	RoleUser      Role = "user"
+	RoleModerator Role = "moderator"
+	RoleAdmin     Role = "admin"
+)
+
+// Permission is an action only some roles are allowed to take
+type Permission string
+
+const (
+	PermissionViewMetrics    Permission = "metrics:view"
+	PermissionListUsers      Permission = "users:list"
+	PermissionSuspendUsers   Permission = "users:suspend"
+	PermissionManageRoles    Permission = "users:roles"
+	PermissionDeleteAnyChirp Permission = "chirps:delete_any"
+	PermissionViewAudit      Permission = "audit:view"
+)
+
+var rolePermissions = map[Role][]Permission{
+	RoleUser: {},
+	RoleModerator: {
+		PermissionListUsers,
+		PermissionSuspendUsers,
+		PermissionDeleteAnyChirp,
+	},
+	RoleAdmin: {
+		PermissionViewMetrics,
+		PermissionListUsers,
+		PermissionSuspendUsers,
+		PermissionManageRoles,
+		PermissionDeleteAnyChirp,
+		PermissionViewAudit,
+	},
+}
+
+var roleRanks = map[Role]int{RoleUser: 0, RoleModerator: 1, RoleAdmin: 2}
+
+var (
+	ErrInvalidRole   = errors.New("role must be one of user, moderator or admin")
+	ErrNotPermitted  = errors.New("not permitted")
+	ErrUserSuspended = errors.New("account is suspended")
+	ErrNotSuspended  = errors.New("account is not suspended")
+)
+
+// ParseRole validates a role name
+func ParseRole(value string) (Role, error) {
+	role := Role(value)
+	if _, ok := rolePermissions[role]; !ok {
+		return "", ErrInvalidRole
+	}
+	return role, nil
+}
+
+// Can reports whether the role grants the permission
+func (r Role) Can(permission Permission) bool {
+	for _, p := range rolePermissions[r] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// Outranks reports whether the role is higher than the other role
+func (r Role) Outranks(other Role) bool {
+	return roleRanks[r] > roleRanks[other]
+}
+
+// GetRole returns the role of the user. Users stored before roles existed are plain users
+func (u User) GetRole() Role {
+	if u.Role == "" {
+		return RoleUser
+	}
+	return u.Role
+}
+
+// IsSuspended reports whether the account was suspended by a moderator
+func (u User) IsSuspended() bool {
+	return u.SuspendedAt != nil
+}
+
+// GetUsers returns every user which didn't delete their account, ordered by id
+func (db *DB) GetUsers() ([]User, error) {
+	structure, err := db.LoadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	users := make([]User, 0, len(structure.Users))
+	for _, user := range structure.Users {
+		if !user.IsDeleted() {
+			users = append(users, user)
+		}
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].ID < users[j].ID
+	})
+
+	return users, nil
+}
+
+// SetUserRole changes the role of the user
+func (db *DB) SetUserRole(userId int, role Role) (User, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	structure, err := db.LoadDB()
+	if err != nil {
+		return User{}, err
+	}
+
+	user, ok := structure.Users[userId]
+	if !ok || user.IsDeleted() {
+		return User{}, ErrUserNotFound
+	}
+	user.Role = role
+	structure.Users[userId] = user
+
+	return user, db.WriteDB(structure)
+}
+
+// SuspendUser suspends the account of the user on behalf of the actor.
+// Suspended users can't log in and every token issued to them is revoked.
+// Users can only be suspended by someone who outranks them
+func (db *DB) SuspendUser(actor User, userId int, now time.Time) (User, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	structure, err := db.LoadDB()
+	if err != nil {
+		return User{}, err
+	}
+
+	user, ok := structure.Users[userId]
+	if !ok || user.IsDeleted() {
+		return User{}, ErrUserNotFound
+	}
+	if !actor.GetRole().Outranks(user.GetRole()) {
+		return User{}, ErrNotPermitted
+	}
+	if user.IsSuspended() {
+		return user, nil
+	}
+
+	suspendedAt := now.UTC()
+	user.SuspendedAt = &suspendedAt
+	user.TokensValidAfter = suspendedAt.Unix()
+	structure.Users[userId] = user
+	structure.revokeUserTokens(userId, now)
+
+	return user, db.WriteDB(structure)
+}
+
+// UnsuspendUser lifts the suspension of the account
+func (db *DB) UnsuspendUser(actor User, userId int) (User, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	structure, err := db.LoadDB()
+	if err != nil {
+		return User{}, err
+	}
+
+	user, ok := structure.Users[userId]
+	if !ok || user.IsDeleted() {
+		return User{}, ErrUserNotFound
+	}
+	if !actor.GetRole().Outranks(user.GetRole()) {
+		return User{}, ErrNotPermitted
+	}
+	if !user.IsSuspended() {
+		return User{}, ErrNotSuspended
+	}
+
+	user.SuspendedAt = nil
+	structure.Users[userId] = user
+
+	return user, db.WriteDB(structure)
+}
+
+// RemoveChirp deletes a chirp regardless of its author
+func (db *DB) RemoveChirp(chirpId int) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	structure, err := db.LoadDB()
+	if err != nil {
+		return err
+	}
+
+	if _, ok := structure.Chirps[chirpId]; !ok {
+		return ErrChirpNotFound
+	}
+	delete(structure.Chirps, chirpId)
+	structure.removeChirpReferences(chirpId)
+
+	return db.WriteDB(structure)
+}
+
+// BootstrapAdmin makes the user with the email an admin. If there is no
+// such user it is created with the password. It reports whether the user was created
+func (db *DB) BootstrapAdmin(email, password string) (User, bool, error) {
+	structure, err := db.LoadDB()
+	if err != nil {
+		return User{}, false, err
+	}
+
+	for _, user := range structure.Users {
+		if EmailsEqual(user.Email, email) && !user.IsDeleted() {
+			user, err = db.SetUserRole(user.ID, RoleAdmin)
+			return user, false, err
+		}
+	}
+
+	user, err := db.CreateUser(password, email, Profile{})
+	if err != nil {
+		return User{}, false, err
+	}
+	user, err = db.SetUserRole(user.ID, RoleAdmin)
+	return user, true, err
+}
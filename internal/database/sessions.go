@@ -0,0 +1,125 @@
+package database
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// Session is a single login of a user. The refresh tokens rotated
+// from that login form a family which shares the id of the session
+type Session struct {
+	ID         string     `json:"id"`
+	UserId     int        `json:"user_id"`
+	UserAgent  string     `json:"user_agent"`
+	IP         string     `json:"ip"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt time.Time  `json:"last_used_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	// ClientId is set on sessions granted to an OAuth client,
+	// which can only use the granted scopes
+	ClientId string   `json:"client_id,omitempty"`
+	Scopes   []string `json:"scopes,omitempty"`
+}
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// IsActive reports whether the session can still be used
+func (s Session) IsActive(now time.Time) bool {
+	return s.RevokedAt == nil && s.ExpiresAt.After(now)
+}
+
+// CreateSession stores a new session together with its first refresh token
+func (db *DB) CreateSession(session Session, token RefreshToken) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	structure, err := db.LoadDB()
+	if err != nil {
+		return err
+	}
+
+	token.FamilyId = session.ID
+	structure.Sessions[session.ID] = session
+	structure.RefreshTokens[token.ID] = token
+
+	return db.WriteDB(structure)
+}
+
+// GetSession returns a session by id
+func (db *DB) GetSession(sessionId string) (Session, error) {
+	structure, err := db.LoadDB()
+	if err != nil {
+		return Session{}, err
+	}
+
+	session, ok := structure.Sessions[sessionId]
+	if !ok {
+		return Session{}, ErrSessionNotFound
+	}
+
+	return session, nil
+}
+
+// GetUserSessions returns the active sessions of the user, most recently used first
+func (db *DB) GetUserSessions(userId int, now time.Time) ([]Session, error) {
+	structure, err := db.LoadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]Session, 0)
+	for _, session := range structure.Sessions {
+		if session.UserId == userId && session.IsActive(now) {
+			sessions = append(sessions, session)
+		}
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastUsedAt.After(sessions[j].LastUsedAt)
+	})
+
+	return sessions, nil
+}
+
+// RevokeSession revokes a session of the user and every refresh token issued for it
+func (db *DB) RevokeSession(userId int, sessionId string, now time.Time) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	structure, err := db.LoadDB()
+	if err != nil {
+		return err
+	}
+
+	session, ok := structure.Sessions[sessionId]
+	if !ok || session.UserId != userId || !session.IsActive(now) {
+		return ErrSessionNotFound
+	}
+	structure.revokeTokenFamily(sessionId, now)
+
+	return db.WriteDB(structure)
+}
+
+// RevokeAllSessions logs the user out everywhere. Every session and
+// refresh token is revoked and every access token issued so far is rejected
+func (db *DB) RevokeAllSessions(userId int, now time.Time) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	structure, err := db.LoadDB()
+	if err != nil {
+		return err
+	}
+
+	user, ok := structure.Users[userId]
+	if !ok {
+		return ErrUserNotFound
+	}
+	user.TokensValidAfter = now.Unix()
+	structure.Users[userId] = user
+	structure.revokeUserTokens(userId, now)
+
+	return db.WriteDB(structure)
+}
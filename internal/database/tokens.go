@@ -0,0 +1,290 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// RefreshToken is the server side record of an issued refresh token.
+// Every refresh rotates the token, the tokens rotated from the same
+// login form a family
+type RefreshToken struct {
+	ID        string    `json:"id"`
+	FamilyId  string    `json:"family_id"`
+	UserId    int       `json:"user_id"`
+	IssuedAt  time.Time `json:"issued_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// ReplacedBy is the id of the token this one was rotated into
+	ReplacedBy string     `json:"replaced_by,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// RevokedToken is a revoked token, identified by its jti claim.
+// It is kept until the token would have expired anyway
+type RevokedToken struct {
+	ID        string    `json:"id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// UnmarshalJSON also accepts the raw tokens older versions stored in the
+// revoked token map. Those have no expiry and are dropped by the next sweep
+func (t *RevokedToken) UnmarshalJSON(data []byte) error {
+	var legacy string
+	if json.Unmarshal(data, &legacy) == nil {
+		*t = RevokedToken{}
+		return nil
+	}
+
+	type revokedToken RevokedToken
+	return json.Unmarshal(data, (*revokedToken)(t))
+}
+
+var (
+	ErrTokenNotFound = errors.New("refresh token not found")
+	ErrTokenRevoked  = errors.New("refresh token has been revoked")
+	ErrTokenReused   = errors.New("refresh token has already been used")
+)
+
+// CreateRefreshToken stores the record of a newly issued refresh token
+func (db *DB) CreateRefreshToken(token RefreshToken) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	structure, err := db.LoadDB()
+	if err != nil {
+		return err
+	}
+
+	structure.RefreshTokens[token.ID] = token
+
+	return db.WriteDB(structure)
+}
+
+// RotateRefreshToken replaces the old refresh token with the new one.
+// Presenting a token that was already rotated means it was stolen,
+// so the whole family is revoked and ErrTokenReused is returned
+func (db *DB) RotateRefreshToken(oldId string, newToken RefreshToken, now time.Time) (RefreshToken, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	structure, err := db.LoadDB()
+	if err != nil {
+		return RefreshToken{}, err
+	}
+
+	oldToken, ok := structure.RefreshTokens[oldId]
+	if !ok {
+		return RefreshToken{}, ErrTokenNotFound
+	}
+	if oldToken.RevokedAt != nil {
+		return RefreshToken{}, ErrTokenRevoked
+	}
+	if oldToken.ReplacedBy != "" {
+		structure.revokeTokenFamily(oldToken.FamilyId, now)
+		err = db.WriteDB(structure)
+		if err != nil {
+			return RefreshToken{}, err
+		}
+		return RefreshToken{}, ErrTokenReused
+	}
+
+	oldToken.ReplacedBy = newToken.ID
+	structure.RefreshTokens[oldId] = oldToken
+
+	newToken.FamilyId = oldToken.FamilyId
+	newToken.UserId = oldToken.UserId
+	structure.RefreshTokens[newToken.ID] = newToken
+
+	// Keep the session of the family alive
+	if session, ok := structure.Sessions[oldToken.FamilyId]; ok {
+		session.LastUsedAt = now.UTC()
+		session.ExpiresAt = newToken.ExpiresAt
+		structure.Sessions[session.ID] = session
+	}
+
+	err = db.WriteDB(structure)
+	if err != nil {
+		return RefreshToken{}, err
+	}
+
+	return newToken, nil
+}
+
+// RevokeRefreshToken revokes the family of the refresh token,
+// which ends the login it was issued for
+func (db *DB) RevokeRefreshToken(id string, now time.Time) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	structure, err := db.LoadDB()
+	if err != nil {
+		return err
+	}
+
+	token, ok := structure.RefreshTokens[id]
+	if !ok {
+		return ErrTokenNotFound
+	}
+	if token.RevokedAt != nil {
+		return ErrTokenRevoked
+	}
+	structure.revokeTokenFamily(token.FamilyId, now)
+
+	return db.WriteDB(structure)
+}
+
+// RevokeUserRefreshTokens revokes every refresh token of the user
+func (db *DB) RevokeUserRefreshTokens(userId int, now time.Time) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	structure, err := db.LoadDB()
+	if err != nil {
+		return err
+	}
+
+	structure.revokeUserTokens(userId, now)
+
+	return db.WriteDB(structure)
+}
+
+// RevokeToken revokes a token by its id until it expires
+func (db *DB) RevokeToken(id string, expiresAt time.Time) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	structure, err := db.LoadDB()
+	if err != nil {
+		return err
+	}
+
+	structure.RevokedTokens[id] = RevokedToken{ID: id, ExpiresAt: expiresAt.UTC()}
+
+	return db.WriteDB(structure)
+}
+
+// IsTokenRevoked reports whether the token with the id has been revoked
+func (db *DB) IsTokenRevoked(id string) (bool, error) {
+	structure, err := db.LoadDB()
+	if err != nil {
+		return false, err
+	}
+
+	_, ok := structure.RevokedTokens[id]
+	return ok, nil
+}
+
+// RevokedTokenCount returns the size of the revoked token store
+func (db *DB) RevokedTokenCount() (int, error) {
+	structure, err := db.LoadDB()
+	if err != nil {
+		return 0, err
+	}
+
+	return len(structure.RevokedTokens), nil
+}
+
+// SweepExpiredTokens drops revoked tokens, refresh token records,
+// sessions and authorization codes past their expiry. Expired tokens
+// are rejected by their exp claim, so keeping them around is not needed.
+// It returns the number of dropped revoked tokens
+func (db *DB) SweepExpiredTokens(now time.Time) (int, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	structure, err := db.LoadDB()
+	if err != nil {
+		return 0, err
+	}
+
+	swept := 0
+	for id, token := range structure.RevokedTokens {
+		if !token.ExpiresAt.After(now) {
+			delete(structure.RevokedTokens, id)
+			swept++
+		}
+	}
+
+	expiredRecords := 0
+	for id, token := range structure.RefreshTokens {
+		if !token.ExpiresAt.After(now) {
+			delete(structure.RefreshTokens, id)
+			expiredRecords++
+		}
+	}
+
+	for id, session := range structure.Sessions {
+		if !session.ExpiresAt.After(now) {
+			delete(structure.Sessions, id)
+			expiredRecords++
+		}
+	}
+
+	for hash, code := range structure.AuthorizationCodes {
+		if !code.ExpiresAt.After(now) {
+			delete(structure.AuthorizationCodes, hash)
+			expiredRecords++
+		}
+	}
+
+	for hash, reset := range structure.PasswordResets {
+		if !reset.ExpiresAt.After(now) {
+			delete(structure.PasswordResets, hash)
+			expiredRecords++
+		}
+	}
+
+	for hash, verification := range structure.EmailVerifications {
+		if !verification.ExpiresAt.After(now) {
+			delete(structure.EmailVerifications, hash)
+			expiredRecords++
+		}
+	}
+
+	for key, attempts := range structure.LoginAttempts {
+		if attempts.IsExpired(now) {
+			delete(structure.LoginAttempts, key)
+			expiredRecords++
+		}
+	}
+
+	if swept == 0 && expiredRecords == 0 {
+		return 0, nil
+	}
+
+	return swept, db.WriteDB(structure)
+}
+
+// revokeTokenFamily revokes every refresh token of the family
+// and the session the family belongs to
+func (s *DBStructure) revokeTokenFamily(familyId string, now time.Time) {
+	revokedAt := now.UTC()
+	if session, ok := s.Sessions[familyId]; ok && session.RevokedAt == nil {
+		session.RevokedAt = &revokedAt
+		s.Sessions[familyId] = session
+	}
+	for id, token := range s.RefreshTokens {
+		if token.FamilyId == familyId && token.RevokedAt == nil {
+			token.RevokedAt = &revokedAt
+			s.RefreshTokens[id] = token
+		}
+	}
+}
+
+// revokeUserTokens revokes every refresh token and session of the user
+func (s *DBStructure) revokeUserTokens(userId int, now time.Time) {
+	revokedAt := now.UTC()
+	for id, session := range s.Sessions {
+		if session.UserId == userId && session.RevokedAt == nil {
+			session.RevokedAt = &revokedAt
+			s.Sessions[id] = session
+		}
+	}
+	for id, token := range s.RefreshTokens {
+		if token.UserId == userId && token.RevokedAt == nil {
+			token.RevokedAt = &revokedAt
+			s.RefreshTokens[id] = token
+		}
+	}
+}
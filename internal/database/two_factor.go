@@ -0,0 +1,201 @@
+package database
+
+import (
+	"errors"
+	"time"
+
+	"github.com/mustafa-mun/chirpy-bootdev/internal/totp"
+)
+
+// TwoFactor is the TOTP second factor of a user. It is pending until
+// the user confirmed it with a first code
+type TwoFactor struct {
+	Secret    string     `json:"secret"`
+	EnabledAt *time.Time `json:"enabled_at,omitempty"`
+	// LastCounter is the time step of the last accepted code,
+	// codes of it or earlier steps can't be used again
+	LastCounter int64 `json:"last_counter,omitempty"`
+	// RecoveryCodes are the hashes of the unused recovery codes
+	RecoveryCodes []string `json:"recovery_codes,omitempty"`
+}
+
+var (
+	ErrTwoFactorEnabled    = errors.New("two-factor authentication is already enabled")
+	ErrTwoFactorNotEnabled = errors.New("two-factor authentication is not enabled")
+	ErrTwoFactorNotStarted = errors.New("two-factor enrollment has not been started")
+	ErrInvalidTOTPCode     = errors.New("one-time code is invalid")
+)
+
+// HasTwoFactor reports whether the user logs in with a second factor
+func (u User) HasTwoFactor() bool {
+	return u.TwoFactor != nil && u.TwoFactor.EnabledAt != nil
+}
+
+// StartTwoFactor stores the secret of a pending enrollment,
+// replacing the secret of an earlier unconfirmed enrollment
+func (db *DB) StartTwoFactor(userId int, secret string) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	structure, err := db.LoadDB()
+	if err != nil {
+		return err
+	}
+
+	user, ok := structure.Users[userId]
+	if !ok || user.IsDeleted() {
+		return ErrUserNotFound
+	}
+	if user.HasTwoFactor() {
+		return ErrTwoFactorEnabled
+	}
+
+	user.TwoFactor = &TwoFactor{Secret: secret}
+	structure.Users[userId] = user
+
+	return db.WriteDB(structure)
+}
+
+// ConfirmTwoFactor enables the pending second factor of the user once the
+// code matches its secret. The recovery codes replace any earlier ones
+func (db *DB) ConfirmTwoFactor(userId int, code string, recoveryCodes []string, now time.Time) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	structure, err := db.LoadDB()
+	if err != nil {
+		return err
+	}
+
+	user, ok := structure.Users[userId]
+	if !ok || user.IsDeleted() {
+		return ErrUserNotFound
+	}
+	if user.HasTwoFactor() {
+		return ErrTwoFactorEnabled
+	}
+	if user.TwoFactor == nil {
+		return ErrTwoFactorNotStarted
+	}
+
+	counter, ok := totp.Validate(user.TwoFactor.Secret, code, now)
+	if !ok {
+		return ErrInvalidTOTPCode
+	}
+
+	enabledAt := now.UTC()
+	user.TwoFactor.EnabledAt = &enabledAt
+	user.TwoFactor.LastCounter = counter
+	user.TwoFactor.RecoveryCodes = recoveryCodes
+	structure.Users[userId] = user
+
+	return db.WriteDB(structure)
+}
+
+// UseTwoFactorCode checks the TOTP code of the user. Every code is only
+// accepted once, so an observed code can't be replayed
+func (db *DB) UseTwoFactorCode(userId int, code string, now time.Time) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	structure, err := db.LoadDB()
+	if err != nil {
+		return err
+	}
+
+	user, ok := structure.Users[userId]
+	if !ok {
+		return ErrUserNotFound
+	}
+	if !user.HasTwoFactor() {
+		return ErrTwoFactorNotEnabled
+	}
+
+	counter, ok := totp.Validate(user.TwoFactor.Secret, code, now)
+	if !ok || counter <= user.TwoFactor.LastCounter {
+		return ErrInvalidTOTPCode
+	}
+
+	user.TwoFactor.LastCounter = counter
+	structure.Users[userId] = user
+
+	return db.WriteDB(structure)
+}
+
+// UseRecoveryCode uses up the recovery code with the hash
+func (db *DB) UseRecoveryCode(userId int, hash string) (remaining int, err error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	structure, err := db.LoadDB()
+	if err != nil {
+		return 0, err
+	}
+
+	user, ok := structure.Users[userId]
+	if !ok {
+		return 0, ErrUserNotFound
+	}
+	if !user.HasTwoFactor() {
+		return 0, ErrTwoFactorNotEnabled
+	}
+
+	codes := user.TwoFactor.RecoveryCodes
+	for i, code := range codes {
+		if code == hash {
+			user.TwoFactor.RecoveryCodes = append(codes[:i:i], codes[i+1:]...)
+			structure.Users[userId] = user
+			return len(user.TwoFactor.RecoveryCodes), db.WriteDB(structure)
+		}
+	}
+
+	return 0, ErrInvalidTOTPCode
+}
+
+// ReplaceRecoveryCodes replaces every recovery code of the user
+func (db *DB) ReplaceRecoveryCodes(userId int, recoveryCodes []string) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	structure, err := db.LoadDB()
+	if err != nil {
+		return err
+	}
+
+	user, ok := structure.Users[userId]
+	if !ok || user.IsDeleted() {
+		return ErrUserNotFound
+	}
+	if !user.HasTwoFactor() {
+		return ErrTwoFactorNotEnabled
+	}
+
+	user.TwoFactor.RecoveryCodes = recoveryCodes
+	structure.Users[userId] = user
+
+	return db.WriteDB(structure)
+}
+
+// DisableTwoFactor removes the second factor of the user
+func (db *DB) DisableTwoFactor(userId int) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	structure, err := db.LoadDB()
+	if err != nil {
+		return err
+	}
+
+	user, ok := structure.Users[userId]
+	if !ok || user.IsDeleted() {
+		return ErrUserNotFound
+	}
+	if !user.HasTwoFactor() {
+		return ErrTwoFactorNotEnabled
+	}
+
+	user.TwoFactor = nil
+	structure.Users[userId] = user
+
+	return db.WriteDB(structure)
+}
@@ -0,0 +1,295 @@
+package database
+
+import (
+	"errors"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mustafa-mun/chirpy-bootdev/internal/password"
+)
+
+// Profile holds the public fields of a user
+type Profile struct {
+	Handle      string
+	DisplayName string
+	Bio         string
+	AvatarURL   string
+}
+
+var (
+	ErrUserNotFound     = errors.New("user not found")
+	ErrEmailTaken       = errors.New("user already exists")
+	ErrHandleTaken      = errors.New("handle is already taken")
+	ErrInvalidHandle    = errors.New("handle must be 3-15 characters of letters, numbers and underscores")
+	ErrPasswordRequired = errors.New("password is required")
+	ErrEmailRequired    = errors.New("email is required")
+)
+
+// UserPatch holds the fields to change on a user.
+// Nil fields are left untouched
+type UserPatch struct {
+	Email       *string
+	Password    *string
+	Handle      *string
+	DisplayName *string
+	Bio         *string
+	AvatarURL   *string
+}
+
+var handlePattern = regexp.MustCompile(`^[a-z0-9_]{3,15}$`)
+
+// Profile returns the public profile of the user
+func (u User) Profile() Profile {
+	return Profile{
+		Handle:      u.Handle,
+		DisplayName: u.DisplayName,
+		Bio:         u.Bio,
+		AvatarURL:   u.AvatarURL,
+	}
+}
+
+func (u *User) setProfile(profile Profile) {
+	u.Handle = profile.Handle
+	u.DisplayName = profile.DisplayName
+	u.Bio = profile.Bio
+	u.AvatarURL = profile.AvatarURL
+}
+
+// normalize validates the profile and returns it in its stored form.
+// Handles are case insensitive and stored in lower case
+func (p Profile) normalize() (Profile, error) {
+	p.Handle = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(p.Handle), "@"))
+	if !handlePattern.MatchString(p.Handle) {
+		return Profile{}, ErrInvalidHandle
+	}
+
+	p.DisplayName = strings.TrimSpace(p.DisplayName)
+	if p.DisplayName == "" {
+		p.DisplayName = p.Handle
+	}
+	if len(p.DisplayName) > 50 {
+		return Profile{}, errors.New("display name is too long")
+	}
+
+	p.Bio = strings.TrimSpace(p.Bio)
+	if len(p.Bio) > 160 {
+		return Profile{}, errors.New("bio is too long")
+	}
+
+	p.AvatarURL = strings.TrimSpace(p.AvatarURL)
+	if p.AvatarURL != "" {
+		avatar, err := url.Parse(p.AvatarURL)
+		if err != nil || (avatar.Scheme != "http" && avatar.Scheme != "https") || avatar.Host == "" {
+			return Profile{}, errors.New("avatar url must be an http or https url")
+		}
+		if len(p.AvatarURL) > 2048 {
+			return Profile{}, errors.New("avatar url is too long")
+		}
+	}
+
+	return p, nil
+}
+
+// PatchUser updates only the supplied fields of the user and keeps the rest
+func (db *DB) PatchUser(userId int, patch UserPatch) (User, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	structure, err := db.LoadDB()
+	if err != nil {
+		return User{}, err
+	}
+
+	user, ok := structure.Users[userId]
+	if !ok || user.IsDeleted() {
+		return User{}, ErrUserNotFound
+	}
+
+	if patch.Email != nil {
+		email, err := NormalizeEmail(*patch.Email)
+		if err != nil {
+			return User{}, err
+		}
+		err = db.checkDuplicateUser(email, userId)
+		if err != nil {
+			return User{}, err
+		}
+		// a new email has to be verified again
+		if email != user.Email {
+			user.Email = email
+			user.EmailVerified = false
+			structure.removeEmailVerifications(userId)
+		}
+	}
+
+	if patch.Password != nil {
+		if *patch.Password == "" {
+			return User{}, ErrPasswordRequired
+		}
+		err = password.Validate(*patch.Password)
+		if err != nil {
+			return User{}, err
+		}
+		hashedPassword, err := password.Hash(*patch.Password)
+		if err != nil {
+			return User{}, err
+		}
+		user.Password = hashedPassword
+	}
+
+	profile := user.Profile()
+	if patch.Handle != nil {
+		profile.Handle = *patch.Handle
+	}
+	if patch.DisplayName != nil {
+		profile.DisplayName = *patch.DisplayName
+	}
+	if patch.Bio != nil {
+		profile.Bio = *patch.Bio
+	}
+	if patch.AvatarURL != nil {
+		profile.AvatarURL = *patch.AvatarURL
+	}
+	// users created before profiles existed have no handle yet
+	if profile.Handle == "" {
+		profile.Handle = "user" + strconv.Itoa(userId)
+	}
+	profile, err = profile.normalize()
+	if err != nil {
+		return User{}, err
+	}
+	err = db.checkDuplicateHandle(profile.Handle, userId)
+	if err != nil {
+		return User{}, err
+	}
+	user.setProfile(profile)
+
+	structure.Users[userId] = user
+	err = db.WriteDB(structure)
+	if err != nil {
+		return User{}, err
+	}
+
+	return user, nil
+}
+
+func (db *DB) checkDuplicateHandle(handle string, id int) error {
+	structure, err := db.LoadDB()
+	if err != nil {
+		return err
+	}
+
+	for _, user := range structure.Users {
+		if user.ID != id && user.Handle == handle {
+			return ErrHandleTaken
+		}
+	}
+
+	return nil
+}
+
+// GetUser returns a single user by id
+func (db *DB) GetUser(userId int) (User, error) {
+	structure, err := db.LoadDB()
+	if err != nil {
+		return User{}, err
+	}
+
+	user, ok := structure.Users[userId]
+	if !ok || user.IsDeleted() {
+		return User{}, ErrUserNotFound
+	}
+
+	return user, nil
+}
+
+// GetUserByHandle returns a single user by handle
+func (db *DB) GetUserByHandle(handle string) (User, error) {
+	structure, err := db.LoadDB()
+	if err != nil {
+		return User{}, err
+	}
+
+	handle = strings.ToLower(strings.TrimPrefix(handle, "@"))
+	for _, user := range structure.Users {
+		if user.Handle == handle && !user.IsDeleted() {
+			return user, nil
+		}
+	}
+
+	return User{}, ErrUserNotFound
+}
+
+// GetUserByEmail returns a single user by email
+func (db *DB) GetUserByEmail(email string) (User, error) {
+	structure, err := db.LoadDB()
+	if err != nil {
+		return User{}, err
+	}
+
+	for _, user := range structure.Users {
+		if EmailsEqual(user.Email, email) && !user.IsDeleted() {
+			return user, nil
+		}
+	}
+
+	return User{}, ErrUserNotFound
+}
+
+// GetUserChirps returns the chirps of a single author visible to the viewer.
+// Unlike GetChirps it returns an empty list for authors without chirps
+func (db *DB) GetUserChirps(authorId int, sortQuery string, viewerId int) ([]Chirp, error) {
+	structure, err := db.LoadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	if user, ok := structure.Users[authorId]; !ok || user.IsDeleted() || structure.isBlocked(viewerId, authorId) {
+		return nil, ErrUserNotFound
+	}
+
+	chirps := make([]Chirp, 0)
+	for _, chirp := range structure.Chirps {
+		if chirp.AuthorId == authorId {
+			chirps = append(chirps, chirp)
+		}
+	}
+
+	sort.Slice(chirps, func(i, j int) bool {
+		if sortQuery == "" || sortQuery == "asc" {
+			return chirps[i].ID < chirps[j].ID
+		}
+		return chirps[i].ID > chirps[j].ID
+	})
+
+	return chirps, nil
+}
+
+// UpgradePasswordHash replaces the password hash of the user with a hash of
+// the same password made with the current hasher. Nothing changes if the
+// password was changed since the old hash was read
+func (db *DB) UpgradePasswordHash(userId int, oldHash, newHash string) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	structure, err := db.LoadDB()
+	if err != nil {
+		return err
+	}
+
+	user, ok := structure.Users[userId]
+	if !ok {
+		return ErrUserNotFound
+	}
+	if user.Password != oldHash {
+		return nil
+	}
+
+	user.Password = newHash
+	structure.Users[userId] = user
+
+	return db.WriteDB(structure)
+}
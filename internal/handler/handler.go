@@ -4,24 +4,11 @@ import (
 	"strings"
 	"errors"
 	"encoding/json"
+	"net"
 	"net/http"
 
 )
 
-// Add CORS headers to response
-func MiddlewareCors(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS, PUT, DELETE")
-		w.Header().Set("Access-Control-Allow-Headers", "*")
-		if r.Method == http.MethodOptions {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
-		next.ServeHTTP(w, r)
-	})
-}
-
 func ValidateReqBody(str string, badWords []string) (string, error){
 	lowered_str := strings.ToLower(str)
 	for _, word := range badWords {
@@ -59,3 +46,12 @@ func RespondWithError(w http.ResponseWriter, code int, errorStr string) {
 	w.WriteHeader(code)
 	w.Write(dat)
 }
+
+// ClientIP returns the ip address the request came from
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
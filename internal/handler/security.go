@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SecurityHeaders are the headers which tell browsers how to protect the pages
+type SecurityHeaders struct {
+	// ContentSecurityPolicy is sent with FrameAncestors added as its frame-ancestors directive
+	ContentSecurityPolicy string
+	// FrameAncestors are the sites allowed to frame the pages, like 'none' or 'self'
+	FrameAncestors string
+	ReferrerPolicy string
+	// HSTSMaxAge is how long browsers keep using https only, 0 sends no HSTS.
+	// It is only sent over https, as browsers ignore it over plain http
+	HSTSMaxAge            time.Duration
+	HSTSIncludeSubdomains bool
+	// TrustForwardedProto treats requests with X-Forwarded-Proto: https as
+	// https, for servers behind a proxy which terminates TLS. Only set it
+	// when the proxy overwrites the header, clients can send it too
+	TrustForwardedProto bool
+}
+
+// DefaultSecurityHeaders only let the pages load resources of the site itself,
+// and data: images like the QR code of the two-factor enrollment
+var DefaultSecurityHeaders = SecurityHeaders{
+	ContentSecurityPolicy: "default-src 'self'; img-src 'self' data:; object-src 'none'; base-uri 'self'",
+	FrameAncestors:        "'none'",
+	ReferrerPolicy:        "strict-origin-when-cross-origin",
+	HSTSMaxAge:            180 * 24 * time.Hour,
+}
+
+// MiddlewareSecurityHeaders adds the security headers to every response
+func MiddlewareSecurityHeaders(headers SecurityHeaders) func(http.Handler) http.Handler {
+	csp := headers.ContentSecurityPolicy
+	if headers.FrameAncestors != "" {
+		csp = strings.TrimSuffix(strings.TrimSpace(csp), ";")
+		if csp != "" {
+			csp += "; "
+		}
+		csp += "frame-ancestors " + headers.FrameAncestors
+	}
+
+	// X-Frame-Options is for browsers which don't know frame-ancestors yet
+	frameOptions := ""
+	switch headers.FrameAncestors {
+	case "'none'":
+		frameOptions = "DENY"
+	case "'self'":
+		frameOptions = "SAMEORIGIN"
+	}
+
+	hsts := ""
+	if headers.HSTSMaxAge > 0 {
+		hsts = "max-age=" + strconv.Itoa(int(headers.HSTSMaxAge.Seconds()))
+		if headers.HSTSIncludeSubdomains {
+			hsts += "; includeSubDomains"
+		}
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h := w.Header()
+			h.Set("X-Content-Type-Options", "nosniff")
+			if csp != "" {
+				h.Set("Content-Security-Policy", csp)
+			}
+			if frameOptions != "" {
+				h.Set("X-Frame-Options", frameOptions)
+			}
+			if headers.ReferrerPolicy != "" {
+				h.Set("Referrer-Policy", headers.ReferrerPolicy)
+			}
+			if hsts != "" && headers.isHTTPS(r) {
+				h.Set("Strict-Transport-Security", hsts)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// isHTTPS reports whether the browser sent the request over https
+func (headers SecurityHeaders) isHTTPS(r *http.Request) bool {
+	if r.TLS != nil {
+		return true
+	}
+	return headers.TrustForwardedProto && strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+}
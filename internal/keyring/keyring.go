@@ -0,0 +1,280 @@
+// Package keyring holds the keys Chirpy signs and verifies its JWTs with.
+//
+// Every key has an id which is written to the kid header of the tokens it
+// signs. Only the active key signs new tokens, every other key keeps
+// verifying tokens until its verify_until time, so keys can be rotated
+// without logging everyone out.
+//
+// Keys are configured with a JSON file:
+//
+//	{
+//	  "active": "2026-10",
+//	  "keys": [
+//	    {"kid": "2026-10", "alg": "EdDSA", "private_key_file": "keys/2026-10.pem"},
+//	    {"kid": "2026-04", "alg": "RS256", "private_key_file": "keys/2026-04.pem", "verify_until": "2026-12-01T00:00:00Z"},
+//	    {"kid": "default", "alg": "HS256", "secret_env": "JWT_SECRET", "verify_until": "2026-12-01T00:00:00Z"}
+//	  ]
+//	}
+//
+// Private keys are PEM encoded PKCS#8 keys, for example created with
+// `openssl genpkey -algorithm ed25519` or
+// `openssl genpkey -algorithm RSA -pkeyopt rsa_keygen_bits:2048`.
+package keyring
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// DefaultKeyId is the id of the key used to verify tokens without a kid header,
+// which were issued before signing keys had ids
+const DefaultKeyId = "default"
+
+type Key struct {
+	ID     string
+	Method jwt.SigningMethod
+	// VerifyUntil is the time the key stops verifying tokens, zero means never
+	VerifyUntil time.Time
+
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+type Keyring struct {
+	active *Key
+	keys   map[string]*Key
+}
+
+// JSONWebKey is the public part of a key as described in RFC 7517
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// RSA keys
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Ed25519 keys
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// NewHMACKey creates a HS256 key from a shared secret
+func NewHMACKey(id string, secret []byte) (*Key, error) {
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("key %s: secret is empty", id)
+	}
+	return &Key{ID: id, Method: jwt.SigningMethodHS256, signKey: secret, verifyKey: secret}, nil
+}
+
+// NewRSAKey creates a RS256 key from a PEM encoded private key
+func NewRSAKey(id string, privatePEM []byte) (*Key, error) {
+	privateKey, err := jwt.ParseRSAPrivateKeyFromPEM(privatePEM)
+	if err != nil {
+		return nil, fmt.Errorf("key %s: %w", id, err)
+	}
+	return &Key{ID: id, Method: jwt.SigningMethodRS256, signKey: privateKey, verifyKey: &privateKey.PublicKey}, nil
+}
+
+// NewEdDSAKey creates an EdDSA key from a PEM encoded Ed25519 private key
+func NewEdDSAKey(id string, privatePEM []byte) (*Key, error) {
+	privateKey, err := jwt.ParseEdPrivateKeyFromPEM(privatePEM)
+	if err != nil {
+		return nil, fmt.Errorf("key %s: %w", id, err)
+	}
+	edKey := privateKey.(ed25519.PrivateKey)
+	return &Key{ID: id, Method: jwt.SigningMethodEdDSA, signKey: edKey, verifyKey: edKey.Public()}, nil
+}
+
+// New creates a keyring which signs with the active key
+// and verifies with the active and every other key
+func New(active *Key, others ...*Key) (*Keyring, error) {
+	if active == nil {
+		return nil, errors.New("keyring needs an active key")
+	}
+
+	k := &Keyring{active: active, keys: map[string]*Key{active.ID: active}}
+	for _, key := range others {
+		if _, ok := k.keys[key.ID]; ok {
+			return nil, fmt.Errorf("duplicate key id %s", key.ID)
+		}
+		k.keys[key.ID] = key
+	}
+
+	return k, nil
+}
+
+// LoadFile reads a keyring from its JSON configuration file
+func LoadFile(path string) (*Keyring, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	type keyConfig struct {
+		Kid            string    `json:"kid"`
+		Alg            string    `json:"alg"`
+		PrivateKeyFile string    `json:"private_key_file"`
+		SecretEnv      string    `json:"secret_env"`
+		VerifyUntil    time.Time `json:"verify_until"`
+	}
+	config := struct {
+		Active string      `json:"active"`
+		Keys   []keyConfig `json:"keys"`
+	}{}
+	err = json.Unmarshal(data, &config)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't decode keyring %s: %w", path, err)
+	}
+
+	var active *Key
+	others := make([]*Key, 0, len(config.Keys))
+	for _, keyCfg := range config.Keys {
+		if keyCfg.Kid == "" {
+			return nil, errors.New("every key needs a kid")
+		}
+
+		var key *Key
+		switch keyCfg.Alg {
+		case "HS256":
+			key, err = NewHMACKey(keyCfg.Kid, []byte(os.Getenv(keyCfg.SecretEnv)))
+		case "RS256", "EdDSA":
+			pem, readErr := os.ReadFile(keyCfg.PrivateKeyFile)
+			if readErr != nil {
+				return nil, fmt.Errorf("key %s: %w", keyCfg.Kid, readErr)
+			}
+			if keyCfg.Alg == "RS256" {
+				key, err = NewRSAKey(keyCfg.Kid, pem)
+			} else {
+				key, err = NewEdDSAKey(keyCfg.Kid, pem)
+			}
+		default:
+			return nil, fmt.Errorf("key %s: unsupported algorithm %q", keyCfg.Kid, keyCfg.Alg)
+		}
+		if err != nil {
+			return nil, err
+		}
+		key.VerifyUntil = keyCfg.VerifyUntil
+
+		if key.ID == config.Active {
+			active = key
+		} else {
+			others = append(others, key)
+		}
+	}
+
+	if active == nil {
+		return nil, fmt.Errorf("active key %q is not in the keyring", config.Active)
+	}
+	if !active.VerifyUntil.IsZero() {
+		return nil, errors.New("the active key can't have a verify_until time")
+	}
+
+	return New(active, others...)
+}
+
+// Active returns the key new tokens are signed with
+func (k *Keyring) Active() *Key {
+	return k.active
+}
+
+// Sign signs the claims with the active key and sets the kid header
+func (k *Keyring) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(k.active.Method, claims)
+	token.Header["kid"] = k.active.ID
+	return token.SignedString(k.active.signKey)
+}
+
+// Algorithms returns the signing algorithms of the keys in the keyring
+func (k *Keyring) Algorithms() []string {
+	seen := make(map[string]bool)
+	algorithms := make([]string, 0, len(k.keys))
+	for _, key := range k.keys {
+		alg := key.Method.Alg()
+		if !seen[alg] {
+			seen[alg] = true
+			algorithms = append(algorithms, alg)
+		}
+	}
+	return algorithms
+}
+
+// Keyfunc finds the key a token has to be verified with by its kid header.
+// The algorithm of the token must match the algorithm of the key and
+// retired keys stop verifying after their grace period
+func (k *Keyring) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		kid = DefaultKeyId
+	}
+
+	key, ok := k.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+	}
+	if !key.VerifyUntil.IsZero() && time.Now().After(key.VerifyUntil) {
+		return nil, fmt.Errorf("signing key %q has been retired", kid)
+	}
+
+	return key.verifyKey, nil
+}
+
+// JWKS returns the public keys of the keyring that still verify tokens.
+// Shared HMAC secrets are never published
+func (k *Keyring) JWKS() JSONWebKeySet {
+	set := JSONWebKeySet{Keys: make([]JSONWebKey, 0, len(k.keys))}
+
+	// The active key comes first
+	ordered := []*Key{k.active}
+	for _, key := range k.keys {
+		if key != k.active {
+			ordered = append(ordered, key)
+		}
+	}
+
+	for _, key := range ordered {
+		if !key.VerifyUntil.IsZero() && time.Now().After(key.VerifyUntil) {
+			continue
+		}
+		if jwk, ok := publicJWK(key); ok {
+			set.Keys = append(set.Keys, jwk)
+		}
+	}
+
+	return set
+}
+
+func publicJWK(key *Key) (JSONWebKey, bool) {
+	jwk := JSONWebKey{Kid: key.ID, Use: "sig", Alg: key.Method.Alg()}
+
+	switch publicKey := key.verifyKey.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(publicKey)
+	default:
+		return JSONWebKey{}, false
+	}
+
+	return jwk, true
+}
@@ -0,0 +1,140 @@
+// Package lockout slows down and locks out repeated failed login attempts.
+//
+// Failures are counted per key, like an account or an IP address. After the
+// free attempts every failure doubles the wait before the next attempt is
+// allowed, and too many failures lock the key for a while.
+package lockout
+
+import (
+	"sync"
+	"time"
+)
+
+// Policy decides how long attempts are blocked after failures
+type Policy struct {
+	// FreeAttempts is the number of failures before attempts are slowed down
+	FreeAttempts int
+	// BaseDelay is the wait after the first failure past the free attempts,
+	// it doubles with every further failure up to MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// LockoutAfter failures lock the key for LockoutDuration, 0 never locks it
+	LockoutAfter    int
+	LockoutDuration time.Duration
+	// ResetAfter is how long failures are remembered after the last one
+	ResetAfter time.Duration
+}
+
+// Attempts are the failed attempts of a key
+type Attempts struct {
+	Failures    int        `json:"failures"`
+	LastFailure time.Time  `json:"last_failure"`
+	LockedUntil *time.Time `json:"locked_until,omitempty"`
+	// ExpiresAt is when the attempts are forgotten
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// IsExpired reports whether the attempts don't matter anymore
+func (a Attempts) IsExpired(now time.Time) bool {
+	return !a.ExpiresAt.After(now)
+}
+
+// Wait returns how long the next attempt has to wait, 0 means it is allowed
+func (p Policy) Wait(a Attempts, now time.Time) time.Duration {
+	if a.IsExpired(now) {
+		return 0
+	}
+	if a.LockedUntil != nil && a.LockedUntil.After(now) {
+		return a.LockedUntil.Sub(now)
+	}
+
+	allowedAt := a.LastFailure.Add(p.delay(a.Failures))
+	if allowedAt.After(now) {
+		return allowedAt.Sub(now)
+	}
+	return 0
+}
+
+// Fail records a failed attempt
+func (p Policy) Fail(a Attempts, now time.Time) Attempts {
+	if a.IsExpired(now) {
+		a = Attempts{}
+	}
+
+	a.Failures++
+	a.LastFailure = now.UTC()
+	a.ExpiresAt = a.LastFailure.Add(p.ResetAfter)
+	if p.LockoutAfter > 0 && a.Failures >= p.LockoutAfter {
+		lockedUntil := a.LastFailure.Add(p.LockoutDuration)
+		a.LockedUntil = &lockedUntil
+		// a locked key starts over once the lockout is over
+		a.Failures = 0
+		if lockedUntil.After(a.ExpiresAt) {
+			a.ExpiresAt = lockedUntil
+		}
+	}
+	return a
+}
+
+// delay is the wait after the failures
+func (p Policy) delay(failures int) time.Duration {
+	if failures <= p.FreeAttempts {
+		return 0
+	}
+
+	delay := p.BaseDelay
+	for i := p.FreeAttempts + 1; i < failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		return p.MaxDelay
+	}
+	return delay
+}
+
+// Tracker keeps the attempts of its keys in memory
+type Tracker struct {
+	Policy   Policy
+	mux      sync.Mutex
+	attempts map[string]Attempts
+}
+
+func NewTracker(policy Policy) *Tracker {
+	return &Tracker{Policy: policy, attempts: make(map[string]Attempts)}
+}
+
+// Wait returns how long the next attempt of the key has to wait
+func (t *Tracker) Wait(key string, now time.Time) time.Duration {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	return t.Policy.Wait(t.attempts[key], now)
+}
+
+// Fail records a failed attempt of the key
+func (t *Tracker) Fail(key string, now time.Time) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	t.attempts[key] = t.Policy.Fail(t.attempts[key], now)
+}
+
+// Reset forgets the failures of the key
+func (t *Tracker) Reset(key string) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	delete(t.attempts, key)
+}
+
+// Sweep drops the attempts which expired
+func (t *Tracker) Sweep(now time.Time) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	for key, attempts := range t.attempts {
+		if attempts.IsExpired(now) {
+			delete(t.attempts, key)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+// Package mailer delivers the emails Chirpy sends to its users.
+//
+// Production uses the SMTP mailer. The file mailer writes every message to
+// a directory so links can be followed during local development, and the
+// memory mailer keeps them for tests.
+package mailer
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends a message to its recipient
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// SMTPMailer sends messages through an SMTP server
+type SMTPMailer struct {
+	// Addr is the host:port of the server
+	Addr string
+	// From is the From header, like "Chirpy <no-reply@example.com>".
+	// Its address is the envelope sender
+	From     string
+	Username string
+	Password string
+}
+
+func (m *SMTPMailer) Send(msg Message) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		host, _, err := net.SplitHostPort(m.Addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", m.Username, m.Password, host)
+	}
+
+	// The server only takes the bare address, without the display name
+	from, err := mail.ParseAddress(m.From)
+	if err != nil {
+		return err
+	}
+	return smtp.SendMail(m.Addr, auth, from.Address, []string{msg.To}, format(m.From, msg))
+}
+
+// FileMailer writes every message into a file of its directory
+type FileMailer struct {
+	Dir  string
+	From string
+}
+
+func (m *FileMailer) Send(msg Message) error {
+	err := os.MkdirAll(m.Dir, 0o700)
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), strings.NewReplacer("@", "_at_", "/", "_").Replace(msg.To))
+	return os.WriteFile(filepath.Join(m.Dir, name), format(m.From, msg), 0o600)
+}
+
+// MemoryMailer keeps every message in memory
+type MemoryMailer struct {
+	mux      sync.Mutex
+	messages []Message
+}
+
+func (m *MemoryMailer) Send(msg Message) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// Messages returns the messages sent so far
+func (m *MemoryMailer) Messages() []Message {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	return append([]Message(nil), m.messages...)
+}
+
+// New creates the mailer of the kind: smtp, file or memory
+func New(kind string, smtpMailer SMTPMailer, dir string) (Mailer, error) {
+	switch kind {
+	case "smtp":
+		if smtpMailer.Addr == "" || smtpMailer.From == "" {
+			return nil, errors.New("the smtp mailer needs an address and a from address")
+		}
+		_, err := mail.ParseAddress(smtpMailer.From)
+		if err != nil {
+			return nil, fmt.Errorf("invalid from address %q: %w", smtpMailer.From, err)
+		}
+		return &smtpMailer, nil
+	case "file":
+		return &FileMailer{Dir: dir, From: smtpMailer.From}, nil
+	case "memory":
+		return &MemoryMailer{}, nil
+	}
+	return nil, fmt.Errorf("unknown mailer %q, must be one of smtp, file or memory", kind)
+}
+
+// format renders the message with its headers. Header values are stripped
+// of line breaks so they can't inject headers of their own
+func format(from string, msg Message) []byte {
+	clean := strings.NewReplacer("\r", "", "\n", "")
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", clean.Replace(from))
+	fmt.Fprintf(&b, "To: %s\r\n", clean.Replace(msg.To))
+	fmt.Fprintf(&b, "Subject: %s\r\n", clean.Replace(msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
@@ -0,0 +1,110 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Argon2id hashes passwords with Argon2id. Hashes are encoded in the
+// PHC string format: $argon2id$v=19$m=65536,t=3,p=4$<salt>$<hash>
+type Argon2id struct {
+	// Memory is the memory used in KiB
+	Memory  uint32
+	Time    uint32
+	Threads uint8
+	SaltLen uint32
+	KeyLen  uint32
+}
+
+// DefaultArgon2id uses the parameters recommended by RFC 9106
+// for memory constrained environments
+var DefaultArgon2id = Argon2id{Memory: 64 * 1024, Time: 3, Threads: 4, SaltLen: 16, KeyLen: 32}
+
+const argon2idPrefix = "$argon2id$"
+
+// Validate checks the parameters argon2 would panic or fail on
+func (a Argon2id) Validate() error {
+	switch {
+	case a.Time < 1:
+		return fmt.Errorf("%w: argon2 time must be at least 1", ErrInvalidParameters)
+	case a.Threads < 1:
+		return fmt.Errorf("%w: argon2 threads must be at least 1", ErrInvalidParameters)
+	case a.Memory < 8*uint32(a.Threads):
+		return fmt.Errorf("%w: argon2 memory must be at least 8 KiB per thread", ErrInvalidParameters)
+	}
+	return nil
+}
+
+func (a Argon2id) Hash(password string) (string, error) {
+	salt := make([]byte, a.SaltLen)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, a.Time, a.Memory, a.Threads, a.KeyLen)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2idPrefix, argon2.Version, a.Memory, a.Time, a.Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (a Argon2id) Verify(encoded, password string) error {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return err
+	}
+
+	other := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, uint32(len(key)))
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return ErrMismatch
+	}
+	return nil
+}
+
+func (a Argon2id) Identifies(encoded string) bool {
+	return strings.HasPrefix(encoded, argon2idPrefix)
+}
+
+func (a Argon2id) IsCurrent(encoded string) bool {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false
+	}
+	return params.Memory == a.Memory && params.Time == a.Time && params.Threads == a.Threads &&
+		uint32(len(salt)) == a.SaltLen && uint32(len(key)) == a.KeyLen
+}
+
+// decodeArgon2id parses an encoded hash into its parameters, salt and key
+func decodeArgon2id(encoded string) (Argon2id, []byte, []byte, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return Argon2id{}, nil, nil, ErrMalformedHash
+	}
+
+	var version int
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return Argon2id{}, nil, nil, ErrMalformedHash
+	}
+
+	params := Argon2id{}
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads)
+	if err != nil || params.Memory == 0 || params.Time == 0 || params.Threads == 0 {
+		return Argon2id{}, nil, nil, ErrMalformedHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return Argon2id{}, nil, nil, ErrMalformedHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return Argon2id{}, nil, nil, ErrMalformedHash
+	}
+
+	return params, salt, key, nil
+}
@@ -0,0 +1,87 @@
+// Package password hashes and checks the passwords of users.
+//
+// Hashes are stored in an encoded form which names their algorithm and
+// parameters, so hashes of every supported algorithm can be verified while
+// new passwords are hashed with the configured one. Hashes made with other
+// settings are reported by NeedsRehash and upgraded on the next login.
+package password
+
+import (
+	"errors"
+	"strings"
+	"sync"
+)
+
+// Hasher hashes passwords with one algorithm
+type Hasher interface {
+	// Hash returns the encoded hash of the password
+	Hash(password string) (string, error)
+	// Verify checks the password against an encoded hash of the algorithm
+	Verify(encoded, password string) error
+	// Identifies reports whether the encoded hash was made by the algorithm
+	Identifies(encoded string) bool
+	// IsCurrent reports whether the encoded hash uses the parameters of the hasher
+	IsCurrent(encoded string) bool
+}
+
+var (
+	ErrMismatch      = errors.New("password does not match")
+	ErrUnknownHash   = errors.New("unknown password hash format")
+	ErrMalformedHash = errors.New("malformed password hash")
+	ErrUnknownHasher = errors.New("unknown password hasher, must be bcrypt or argon2id")
+	// ErrInvalidParameters is returned by the Validate methods of the hashers
+	ErrInvalidParameters = errors.New("invalid password hasher parameters")
+)
+
+var (
+	mux     sync.RWMutex
+	current Hasher = Bcrypt{Cost: DefaultBcryptCost}
+	// every algorithm hashes can be verified with
+	known = []Hasher{Bcrypt{}, Argon2id{}}
+)
+
+// Configure sets the hasher new passwords are hashed with
+func Configure(hasher Hasher) {
+	mux.Lock()
+	defer mux.Unlock()
+
+	current = hasher
+}
+
+// NewHasher returns the hasher of the algorithm with its default parameters
+func NewHasher(algorithm string) (Hasher, error) {
+	switch strings.ToLower(algorithm) {
+	case "bcrypt":
+		return Bcrypt{Cost: DefaultBcryptCost}, nil
+	case "argon2id":
+		return DefaultArgon2id, nil
+	}
+	return nil, ErrUnknownHasher
+}
+
+// Hash returns the encoded hash of the password made by the configured hasher
+func Hash(password string) (string, error) {
+	mux.RLock()
+	defer mux.RUnlock()
+
+	return current.Hash(password)
+}
+
+// Verify checks the password against the encoded hash of any known algorithm
+func Verify(encoded, password string) error {
+	for _, hasher := range known {
+		if hasher.Identifies(encoded) {
+			return hasher.Verify(encoded, password)
+		}
+	}
+	return ErrUnknownHash
+}
+
+// NeedsRehash reports whether the hash was made with another algorithm or
+// other parameters than the configured hasher uses
+func NeedsRehash(encoded string) bool {
+	mux.RLock()
+	defer mux.RUnlock()
+
+	return !current.Identifies(encoded) || !current.IsCurrent(encoded)
+}
@@ -0,0 +1,103 @@
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	// ErrPolicy is wrapped by every error of passwords the policy doesn't allow
+	ErrPolicy   = errors.New("password is not allowed")
+	ErrBreached = fmt.Errorf("%w: it appears in a list of breached passwords", ErrPolicy)
+)
+
+// Policy decides which passwords users can choose
+type Policy struct {
+	MinLength int
+	// MaxLength is counted in bytes, bcrypt ignores everything after 72
+	MaxLength int
+	// breached holds the upper case SHA-1 hex of breached passwords
+	breached map[string]struct{}
+}
+
+// DefaultPolicy is used until ConfigurePolicy sets another one
+var DefaultPolicy = Policy{MinLength: 8, MaxLength: 72}
+
+var policy = DefaultPolicy
+
+// ConfigurePolicy sets the policy Validate checks passwords against
+func ConfigurePolicy(p Policy) {
+	mux.Lock()
+	defer mux.Unlock()
+
+	policy = p
+}
+
+// Validate checks the password against the configured policy
+func Validate(password string) error {
+	mux.RLock()
+	defer mux.RUnlock()
+
+	return policy.Check(password)
+}
+
+// Check returns why the password isn't allowed, if it isn't
+func (p Policy) Check(password string) error {
+	if utf8.RuneCountInString(password) < p.MinLength {
+		return fmt.Errorf("%w: it must be at least %d characters long", ErrPolicy, p.MinLength)
+	}
+	if p.MaxLength > 0 && len(password) > p.MaxLength {
+		return fmt.Errorf("%w: it can be at most %d bytes long", ErrPolicy, p.MaxLength)
+	}
+	if _, ok := p.breached[sha1Hex(password)]; ok {
+		return ErrBreached
+	}
+	return nil
+}
+
+// LoadBreached reads a list of breached passwords, one per line. A line is
+// either the password itself or its SHA-1 hex as in the Have I Been Pwned
+// downloads, where a ":count" suffix is ignored
+func (p *Policy) LoadBreached(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if p.breached == nil {
+		p.breached = make(map[string]struct{})
+	}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		if hash, _, _ := strings.Cut(line, ":"); isSHA1Hex(hash) {
+			p.breached[strings.ToUpper(hash)] = struct{}{}
+			continue
+		}
+		p.breached[sha1Hex(line)] = struct{}{}
+	}
+	return scanner.Err()
+}
+
+func sha1Hex(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+func isSHA1Hex(s string) bool {
+	if len(s) != 2*sha1.Size {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
@@ -0,0 +1,132 @@
+// Package ratelimit limits how often a client can make requests with
+// token buckets. Every key has a bucket which holds up to the requests of
+// its limit and refills continuously over the period of the limit.
+package ratelimit
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limit allows Requests requests per Period, all of them at once
+// if the bucket of the key is full
+type Limit struct {
+	Requests int
+	Period   time.Duration
+}
+
+var ErrInvalidLimit = errors.New(`rate limit must look like "100/1m"`)
+
+// ParseLimit parses a limit written as requests/period, like "100/1m"
+func ParseLimit(value string) (Limit, error) {
+	requests, period, ok := strings.Cut(value, "/")
+	if !ok {
+		return Limit{}, ErrInvalidLimit
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(requests))
+	if err != nil || n <= 0 {
+		return Limit{}, ErrInvalidLimit
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(period))
+	if err != nil || d <= 0 {
+		return Limit{}, ErrInvalidLimit
+	}
+	return Limit{Requests: n, Period: d}, nil
+}
+
+func (l Limit) String() string {
+	return fmt.Sprintf("%d/%s", l.Requests, l.Period)
+}
+
+// rate is the number of requests the bucket refills per second
+func (l Limit) rate() float64 {
+	return float64(l.Requests) / l.Period.Seconds()
+}
+
+// Result is the state of a bucket after a request was taken from it
+type Result struct {
+	Allowed   bool
+	Limit     Limit
+	Remaining int
+	// Reset is how long until the bucket is full again
+	Reset time.Duration
+	// RetryAfter is how long a rejected request has to wait
+	RetryAfter time.Duration
+}
+
+// Store keeps the buckets. The memory store only limits a single server,
+// servers behind a load balancer need a store they share
+type Store interface {
+	// Take takes a request from the bucket of the key
+	Take(key string, limit Limit, now time.Time) (Result, error)
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	// full is when the bucket is full again and can be forgotten
+	full time.Time
+}
+
+// MemoryStore keeps the buckets in memory
+type MemoryStore struct {
+	mux     sync.Mutex
+	buckets map[string]*bucket
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: make(map[string]*bucket)}
+}
+
+func (s *MemoryStore) Take(key string, limit Limit, now time.Time) (Result, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	capacity := float64(limit.Requests)
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: capacity, updated: now}
+		s.buckets[key] = b
+	}
+
+	// refill the bucket for the time since it was last used
+	elapsed := now.Sub(b.updated).Seconds()
+	if elapsed > 0 {
+		b.tokens = math.Min(capacity, b.tokens+elapsed*limit.rate())
+		b.updated = now
+	}
+
+	result := Result{Limit: limit}
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = seconds((1 - b.tokens) / limit.rate())
+	}
+	result.Remaining = int(b.tokens)
+	result.Reset = seconds((capacity - b.tokens) / limit.rate())
+	b.full = now.Add(result.Reset)
+
+	return result, nil
+}
+
+// Sweep forgets the buckets which are full again
+func (s *MemoryStore) Sweep(now time.Time) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	for key, b := range s.buckets {
+		if !b.full.After(now) {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -5,6 +5,9 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 	"github.com/joho/godotenv"
 )
 
@@ -17,6 +20,9 @@ func LoadDotenv() {
   }
 }
 
+// AdminEmail is the email of the user the -create-admin flag makes an admin
+var AdminEmail = flag.String("create-admin", "", "Make the user with this email an admin and exit. A missing user is created with the ADMIN_PASSWORD password")
+
 func EnableDebugMode() {
 	dbg := flag.Bool("debug", false, "Enable debug mode")
 	flag.Parse()
@@ -27,4 +33,76 @@ func EnableDebugMode() {
 			fmt.Println(os.ErrNotExist)
 		}
 	}
-}
\ No newline at end of file
+}
+
+// GetEnv returns the value of the environment variable
+// or the fallback if it is not set
+func GetEnv(key, fallback string) string {
+	value, ok := os.LookupEnv(key)
+	if !ok || value == "" {
+		return fallback
+	}
+	return value
+}
+
+// GetEnvDuration parses the environment variable as a duration
+// and returns the fallback if it is not set
+func GetEnvDuration(key string, fallback time.Duration) time.Duration {
+	value := GetEnv(key, "")
+	if value == "" {
+		return fallback
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("%s must be a duration: %v", key, err)
+	}
+	return duration
+}
+
+
+// GetEnvInt parses the environment variable as an integer
+// and returns the fallback if it is not set
+func GetEnvInt(key string, fallback int) int {
+	value := GetEnv(key, "")
+	if value == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Fatalf("%s must be an integer: %v", key, err)
+	}
+	return n
+}
+
+// GetEnvBool parses the environment variable as a boolean
+// and returns the fallback if it is not set
+func GetEnvBool(key string, fallback bool) bool {
+	value := GetEnv(key, "")
+	if value == "" {
+		return fallback
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Fatalf("%s must be true or false: %v", key, err)
+	}
+	return b
+}
+
+// GetEnvList splits the comma separated environment variable
+// and returns the fallback if it is not set. "none" is an empty list
+func GetEnvList(key string, fallback []string) []string {
+	value := GetEnv(key, "")
+	if value == "" {
+		return fallback
+	}
+	if value == "none" {
+		return []string{}
+	}
+	list := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
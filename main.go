@@ -1,12 +1,27 @@
 package main
 
 import (
+	"fmt"
+	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/audit"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/auth"
 	"github.com/mustafa-mun/chirpy-bootdev/internal/controller"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/cors"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/csrf"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/database"
 	"github.com/mustafa-mun/chirpy-bootdev/internal/handler"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/keyring"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/lockout"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/mailer"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/password"
+	"github.com/mustafa-mun/chirpy-bootdev/internal/ratelimit"
 	"github.com/mustafa-mun/chirpy-bootdev/internal/sys"
 )
 
@@ -15,48 +30,418 @@ func main() {
 	sys.EnableDebugMode()
 	sys.LoadDotenv()
 	controller.InitDB()
+
+	err := configurePasswords()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Bootstrap the first admin
+	if *sys.AdminEmail != "" {
+		err := controller.BootstrapAdmin(*sys.AdminEmail, os.Getenv("ADMIN_PASSWORD"))
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 	
 	r := chi.NewRouter()
 	apiRouter := chi.NewRouter()
 	adminRouter := chi.NewRouter()
-	corsMux := handler.MiddlewareCors(r)
-	apiCfg := &controller.ApiConfig{FileserverHits: 0, JwtSecret: os.Getenv("JWT_SECRET")}
+	oauthRouter := chi.NewRouter()
+	chirpRetention, err := database.ParseChirpRetention(sys.GetEnv("CHIRP_RETENTION", "delete"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	jwtKeyring, err := loadKeyring()
+	if err != nil {
+		log.Fatal(err)
+	}
+	mail, err := mailer.New(sys.GetEnv("MAILER", "file"), mailer.SMTPMailer{
+		Addr: os.Getenv("SMTP_ADDR"),
+		From: sys.GetEnv("MAIL_FROM", "Chirpy <no-reply@chirpy.local>"),
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+	}, sys.GetEnv("MAIL_DIR", "mail"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	unverifiedRestrictions, err := controller.ParseRestrictions(sys.GetEnv("UNVERIFIED_RESTRICTIONS", string(controller.RestrictPostChirps)))
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Serving https directly needs both, behind a TLS proxy COOKIE_SECURE is enough
+	tlsCertFile, tlsKeyFile := os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE")
+	secureCookies := sys.GetEnvBool("COOKIE_SECURE", tlsCertFile != "")
+	exportSigningKey, err := loadExportSigningKey()
+	if err != nil {
+		log.Fatal(err)
+	}
+	rateLimits := ratelimit.NewMemoryStore()
+	auditLog, err := audit.Open(sys.GetEnv("AUDIT_LOG", "audit.jsonl"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	apiCfg := &controller.ApiConfig{
+		FileserverHits: 0,
+		ExportSigningKey: exportSigningKey,
+		Auth: auth.New(jwtKeyring, sys.GetEnvDuration("JWT_LEEWAY", auth.DefaultLeeway)),
+		ChirpRetention: chirpRetention,
+		DeletionGracePeriod: sys.GetEnvDuration("DELETION_GRACE_PERIOD", 30*24*time.Hour),
+		ExportsDir: sys.GetEnv("EXPORTS_DIR", "exports"),
+		ExportURLTTL: sys.GetEnvDuration("EXPORT_URL_TTL", 15*time.Minute),
+		Mailer: mail,
+		PublicURL: sys.GetEnv("PUBLIC_URL", "http://localhost:"+os.Getenv("PORT")),
+		UnverifiedRestrictions: unverifiedRestrictions,
+		LoginPolicy: lockout.Policy{
+			FreeAttempts: 3,
+			BaseDelay: time.Second,
+			MaxDelay: 5*time.Minute,
+			LockoutAfter: sys.GetEnvInt("LOGIN_LOCKOUT_AFTER", 10),
+			LockoutDuration: sys.GetEnvDuration("LOGIN_LOCKOUT_DURATION", 15*time.Minute),
+			ResetAfter: time.Hour,
+		},
+		// Many users can share an IP, so it is slowed down later and never locked
+		LoginIPAttempts: lockout.NewTracker(lockout.Policy{
+			FreeAttempts: 20,
+			BaseDelay: time.Second,
+			MaxDelay: 15*time.Minute,
+			ResetAfter: time.Hour,
+		}),
+		RateLimits: rateLimits,
+		Audit: auditLog,
+		CSRF: csrf.Protection{Secure: secureCookies},
+		CookieAuth: sys.GetEnvBool("COOKIE_AUTH", false),
+		SecureCookies: secureCookies,
+	}
+
+	// Purge deleted accounts once their grace period is over
+	go func() {
+		for {
+			apiCfg.PurgeDeletedAccounts()
+			time.Sleep(time.Hour)
+		}
+	}()
+
+	// Drop revoked tokens once they expired
+	sweepInterval := sys.GetEnvDuration("TOKEN_SWEEP_INTERVAL", 10*time.Minute)
+	go func() {
+		for {
+			apiCfg.SweepExpiredTokens()
+			time.Sleep(sweepInterval)
+		}
+	}()
+
+	// Forget rate limit buckets once they are full again
+	go func() {
+		for {
+			rateLimits.Sweep(time.Now())
+			time.Sleep(time.Minute)
+		}
+	}()
+
+	r.Use(handler.MiddlewareSecurityHeaders(handler.SecurityHeaders{
+		ContentSecurityPolicy: sys.GetEnv("CONTENT_SECURITY_POLICY", handler.DefaultSecurityHeaders.ContentSecurityPolicy),
+		FrameAncestors: sys.GetEnv("FRAME_ANCESTORS", handler.DefaultSecurityHeaders.FrameAncestors),
+		ReferrerPolicy: sys.GetEnv("REFERRER_POLICY", handler.DefaultSecurityHeaders.ReferrerPolicy),
+		HSTSMaxAge: sys.GetEnvDuration("HSTS_MAX_AGE", handler.DefaultSecurityHeaders.HSTSMaxAge),
+		HSTSIncludeSubdomains: sys.GetEnvBool("HSTS_INCLUDE_SUBDOMAINS", false),
+		TrustForwardedProto: sys.GetEnvBool("TRUST_FORWARDED_PROTO", false),
+	}))
+
+	// Every client IP shares one limit across the whole site
+	r.Use(apiCfg.MiddlewareRateLimit(rateLimit("global", "600/1m", "")))
+	// Sending mails is limited much more
+	mailRateLimit := apiCfg.MiddlewareRateLimit(rateLimit("mail", "5/1h", ""))
 
 	fsHandler := apiCfg.MiddlewareMetricsInc(http.StripPrefix("/app", http.FileServer(http.Dir("."))))
 	r.Handle("/app", fsHandler)
 	r.Handle("/app/*", fsHandler)
+	r.Get("/.well-known/jwks.json", apiCfg.JWKSHandler)
+	r.Get("/reset-password", apiCfg.ResetPasswordPageHandler) // Link of the reset mail
+	r.Post("/reset-password", apiCfg.ResetPasswordPageHandler)
+	r.Get("/verify-email", apiCfg.VerifyEmailPageHandler) // Link of the verification mail
+	r.Post("/verify-email", apiCfg.VerifyEmailPageHandler)
 	r.Mount("/api", apiRouter)
 	r.Mount("/admin", adminRouter)
+	r.Mount("/oauth", oauthRouter)
 
-	adminRouter.Get("/metrics", apiCfg.MetricsHandler)
+	// OAuth authorization server for third party clients
+	oauthRouter.Get("/authorize", apiCfg.AuthorizeHandler) // Consent page
+	oauthRouter.Post("/authorize", apiCfg.AuthorizeHandler)
+	oauthRouter.Post("/token", apiCfg.TokenHandler)
+	oauthRouter.Post("/introspect", apiCfg.IntrospectHandler)
+	oauthRouter.Post("/revoke", apiCfg.OAuthRevokeHandler)
 
-	apiRouter.Get("/healthz", apiCfg.HealthzHandler)
-	apiRouter.Get("/chirps", apiCfg.GetChirpsHandler)
-	apiRouter.Get("/chirps/{chirpId}", apiCfg.GetSingleChirpHandler)
+	// Admin routes, every route checks the permission of the role of the user
+	adminRouter.Use(apiCfg.MiddlewareAuth, apiCfg.MiddlewareSessionOnly)
+	adminRouter.With(apiCfg.MiddlewarePermission(database.PermissionViewMetrics)).Get("/metrics", apiCfg.MetricsHandler)
+	adminRouter.With(apiCfg.MiddlewarePermission(database.PermissionListUsers)).Get("/users", apiCfg.AdminGetUsersHandler)
+	adminRouter.With(apiCfg.MiddlewarePermission(database.PermissionSuspendUsers)).Post("/users/{userId}/suspend", apiCfg.AdminSuspendUserHandler)
+	adminRouter.With(apiCfg.MiddlewarePermission(database.PermissionSuspendUsers)).Delete("/users/{userId}/suspend", apiCfg.AdminUnsuspendUserHandler)
+	adminRouter.With(apiCfg.MiddlewarePermission(database.PermissionSuspendUsers)).Delete("/users/{userId}/lockout", apiCfg.AdminUnlockUserHandler)
+	adminRouter.With(apiCfg.MiddlewarePermission(database.PermissionManageRoles)).Put("/users/{userId}/role", apiCfg.AdminSetRoleHandler)
+	adminRouter.With(apiCfg.MiddlewarePermission(database.PermissionDeleteAnyChirp)).Delete("/chirps/{chirpId}", apiCfg.AdminDeleteChirpHandler)
+	adminRouter.With(apiCfg.MiddlewarePermission(database.PermissionViewAudit)).Get("/audit", apiCfg.AdminGetAuditHandler)
+	adminRouter.With(apiCfg.MiddlewarePermission(database.PermissionViewAudit)).Get("/audit/verify", apiCfg.AdminVerifyAuditHandler)
 
-	apiRouter.Post("/chirps", apiCfg.PostChirpHandler)
-	apiRouter.Post("/users", apiCfg.PostUserHandler)
+	// Public routes, these authenticate with their own credentials if any
+	apiRouter.Get("/healthz", apiCfg.HealthzHandler)
+	apiRouter.With(apiCfg.MiddlewareRateLimit(rateLimit("signup", "10/1h", ""))).Post("/users", apiCfg.PostUserHandler)
 	apiRouter.Post("/login", apiCfg.LoginHandler)
+	apiRouter.Post("/login/mfa", apiCfg.LoginMFAHandler) // Second step of two-factor logins
 	apiRouter.Post("/polka/webhooks", apiCfg.PolkaWebhooksHandler) // polka payment handling 
-
 	apiRouter.Post("/refresh", apiCfg.RefreshTokenHandler) // Refresh access token
 	apiRouter.Post("/revoke", apiCfg.RevokeTokenHandler) // Revoke refresh token
+	apiRouter.Get("/exports/{exportId}/download", apiCfg.DownloadExportHandler) // Signed url
+	apiRouter.With(mailRateLimit).Post("/password-reset", apiCfg.PasswordResetHandler) // Mails a reset link
+	apiRouter.Post("/password-reset/confirm", apiCfg.ConfirmPasswordResetHandler)
+	apiRouter.Post("/verify-email", apiCfg.VerifyEmailHandler) // Token from the verification mail
+
+	// Public routes which show more to logged in users
+	apiRouter.Group(func(r chi.Router) {
+		r.Use(apiCfg.MiddlewareOptionalAuth, apiCfg.MiddlewareScope(auth.ScopeChirpsRead))
+		r.Use(apiCfg.MiddlewareRateLimit(rateLimit("read", "120/1m", "600/1m")))
+
+		r.Get("/chirps", apiCfg.GetChirpsHandler)
+		r.Get("/chirps/{chirpId}", apiCfg.GetSingleChirpHandler)
+		r.Get("/users/{userId}", apiCfg.GetUserHandler)
+		r.Get("/users/{userId}/chirps", apiCfg.GetUserChirpsHandler)
+	})
+
+	// Routes for logged in users, personal access tokens need the scope of the route
+	apiRouter.Group(func(r chi.Router) {
+		r.Use(apiCfg.MiddlewareAuth)
+		r.Use(apiCfg.MiddlewareRateLimit(rateLimit("api", "300/1m", "1200/1m")))
+
+		chirpsRateLimit := apiCfg.MiddlewareRateLimit(rateLimit("chirps-write", "30/1m", "120/1m"))
+		r.With(apiCfg.MiddlewareScope(auth.ScopeChirpsWrite), chirpsRateLimit).Post("/chirps", apiCfg.PostChirpHandler)
+		r.With(apiCfg.MiddlewareScope(auth.ScopeChirpsWrite)).Delete("/chirps/{chirpID}", apiCfg.DeleteChirpHandler)
+
+		r.With(apiCfg.MiddlewareScope(auth.ScopeProfileRead)).Get("/users/me", apiCfg.GetMeHandler)
+		r.With(apiCfg.MiddlewareScope(auth.ScopeProfileWrite)).Patch("/users/me", apiCfg.PatchMeHandler)
+
+		// Bookmarks and private collections
+		r.Group(func(r chi.Router) {
+			r.Use(apiCfg.MiddlewareScope(auth.ScopeBookmarksRead))
+			r.Get("/bookmarks", apiCfg.GetBookmarksHandler)
+			r.Get("/collections", apiCfg.GetCollectionsHandler)
+			r.Get("/collections/{collectionId}", apiCfg.GetCollectionHandler)
+		})
+		r.Group(func(r chi.Router) {
+			r.Use(apiCfg.MiddlewareScope(auth.ScopeBookmarksWrite))
+			r.Post("/bookmarks", apiCfg.PostBookmarkHandler)
+			r.Delete("/bookmarks/{chirpId}", apiCfg.DeleteBookmarkHandler)
+			r.Post("/collections", apiCfg.PostCollectionHandler)
+			r.Delete("/collections/{collectionId}", apiCfg.DeleteCollectionHandler)
+			r.Post("/collections/{collectionId}/chirps", apiCfg.PostCollectionChirpHandler)
+			r.Delete("/collections/{collectionId}/chirps/{chirpId}", apiCfg.DeleteCollectionChirpHandler)
+		})
+
+		// Blocking and muting other users
+		r.Group(func(r chi.Router) {
+			r.Use(apiCfg.MiddlewareScope(auth.ScopeBlocksRead))
+			r.Get("/blocks", apiCfg.GetBlocksHandler)
+			r.Get("/mutes", apiCfg.GetMutesHandler)
+		})
+		r.Group(func(r chi.Router) {
+			r.Use(apiCfg.MiddlewareScope(auth.ScopeBlocksWrite))
+			r.Post("/users/{userId}/block", apiCfg.BlockUserHandler)
+			r.Delete("/users/{userId}/block", apiCfg.UnblockUserHandler)
+			r.Post("/users/{userId}/mute", apiCfg.MuteUserHandler)
+			r.Delete("/users/{userId}/mute", apiCfg.UnmuteUserHandler)
+		})
+
+		// Managing the account itself needs a logged in session
+		r.Group(func(r chi.Router) {
+			r.Use(apiCfg.MiddlewareSessionOnly)
+
+			// Session management
+			r.Get("/sessions", apiCfg.GetSessionsHandler)
+			r.Delete("/sessions", apiCfg.DeleteAllSessionsHandler) // Log out everywhere
+			r.Delete("/sessions/{sessionId}", apiCfg.DeleteSessionHandler)
 
+			// Personal access tokens
+			r.Get("/users/me/tokens", apiCfg.GetPersonalTokensHandler)
+			r.Post("/users/me/tokens", apiCfg.PostPersonalTokenHandler)
+			r.Delete("/users/me/tokens/{tokenId}", apiCfg.DeletePersonalTokenHandler)
 
-	apiRouter.Put("/users", apiCfg.UpdateUserHandler)
+			// OAuth clients registered by the user
+			r.Get("/oauth/clients", apiCfg.GetOAuthClientsHandler)
+			r.Post("/oauth/clients", apiCfg.PostOAuthClientHandler)
+			r.Delete("/oauth/clients/{clientId}", apiCfg.DeleteOAuthClientHandler)
 
-	apiRouter.Delete("/chirps/{chirpID}", apiCfg.DeleteChirpHandler)
+			r.With(mailRateLimit).Post("/users/me/verify-email", apiCfg.ResendVerificationHandler)
+
+			// Two-factor authentication
+			r.Post("/users/me/2fa", apiCfg.PostTwoFactorHandler) // Starts the enrollment
+			r.Post("/users/me/2fa/confirm", apiCfg.ConfirmTwoFactorHandler)
+			r.Post("/users/me/2fa/recovery-codes", apiCfg.PostRecoveryCodesHandler)
+			r.Delete("/users/me/2fa", apiCfg.DeleteTwoFactorHandler)
+
+			r.Put("/users", apiCfg.UpdateUserHandler)
+			r.Delete("/users/me", apiCfg.DeleteMeHandler)
+			r.Post("/users/me/export", apiCfg.PostExportHandler)
+			r.Get("/users/me/exports/{exportId}", apiCfg.GetExportHandler)
+		})
+	})
+
+	// Every route group has its own CORS policy
+	corsMux := cors.Groups(corsPolicy("app", cors.Policy{
+		AllowedOrigins: []string{"*"},
+		AllowedMethods: []string{"GET", "HEAD"},
+	}), map[string]cors.Policy{
+		"/api": corsPolicy("api", cors.Policy{
+			AllowedOrigins: []string{"*"},
+			AllowedMethods: []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"},
+			AllowedHeaders: []string{"Authorization", "Content-Type", csrf.HeaderName},
+			ExposedHeaders: []string{"RateLimit-Policy", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After"},
+			MaxAge: 10*time.Minute,
+		}),
+		"/oauth": corsPolicy("oauth", cors.Policy{
+			AllowedOrigins: []string{"*"},
+			AllowedMethods: []string{"GET", "POST"},
+			AllowedHeaders: []string{"Authorization", "Content-Type"},
+			MaxAge: 10*time.Minute,
+		}),
+		// The admin routes are only used from the site itself
+		"/admin": corsPolicy("admin", cors.Policy{}),
+	})(r)
 
 	server := &http.Server{
 		Addr:    ":" + os.Getenv("PORT"),
 		Handler: corsMux,
 	}
+	if tlsCertFile != "" || tlsKeyFile != "" {
+		log.Fatal(server.ListenAndServeTLS(tlsCertFile, tlsKeyFile))
+	}
 	server.ListenAndServe()
 }
 
+// configurePasswords sets the hasher new passwords are hashed with
+// and the policy passwords are checked against
+func configurePasswords() error {
+	hasher, err := password.NewHasher(sys.GetEnv("PASSWORD_HASHER", "bcrypt"))
+	if err != nil {
+		return err
+	}
+	switch h := hasher.(type) {
+	case password.Bcrypt:
+		h.Cost = sys.GetEnvInt("BCRYPT_COST", h.Cost)
+		err = h.Validate()
+		hasher = h
+	case password.Argon2id:
+		var memory, iterations, threads uint64
+		memory, err = envUint("ARGON2_MEMORY_KIB", uint64(h.Memory), 32)
+		if err != nil {
+			return err
+		}
+		iterations, err = envUint("ARGON2_TIME", uint64(h.Time), 32)
+		if err != nil {
+			return err
+		}
+		threads, err = envUint("ARGON2_THREADS", uint64(h.Threads), 8)
+		if err != nil {
+			return err
+		}
+		h.Memory, h.Time, h.Threads = uint32(memory), uint32(iterations), uint8(threads)
+		err = h.Validate()
+		hasher = h
+	}
+	if err != nil {
+		return err
+	}
+	password.Configure(hasher)
+
+	policy := password.DefaultPolicy
+	policy.MinLength = sys.GetEnvInt("PASSWORD_MIN_LENGTH", policy.MinLength)
+	if path := os.Getenv("BREACHED_PASSWORDS_FILE"); path != "" {
+		err = policy.LoadBreached(path)
+		if err != nil {
+			return err
+		}
+	}
+	password.ConfigurePolicy(policy)
+
+	return nil
+}
+
+// envUint parses the environment variable as an unsigned integer of the
+// bit size and returns the fallback if it is not set
+func envUint(key string, fallback uint64, bitSize int) (uint64, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback, nil
+	}
+	n, err := strconv.ParseUint(value, 10, bitSize)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be a positive integer of at most %d bits: %w", key, bitSize, err)
+	}
+	return n, nil
+}
+
+// rateLimit returns the rate limit of a group of routes. RATE_LIMIT_<NAME>
+// and RATE_LIMIT_<NAME>_RED override the limits, like "100/1m"
+func rateLimit(name, limit, redLimit string) controller.RateLimit {
+	key := "RATE_LIMIT_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+	rl := controller.RateLimit{Name: name, Limit: parseLimit(key, sys.GetEnv(key, limit))}
+	if value := sys.GetEnv(key+"_RED", redLimit); value != "" {
+		rl.RedLimit = parseLimit(key+"_RED", value)
+	}
+	return rl
+}
 
+func parseLimit(key, value string) ratelimit.Limit {
+	limit, err := ratelimit.ParseLimit(value)
+	if err != nil {
+		log.Fatalf("%s: %v", key, err)
+	}
+	return limit
+}
 
+// corsPolicy returns the CORS policy of a route group. CORS_<GROUP>_ORIGINS,
+// _METHODS, _HEADERS, _EXPOSE (comma separated, "none" for an empty list),
+// _CREDENTIALS and _MAX_AGE override the defaults
+func corsPolicy(group string, defaults cors.Policy) cors.Policy {
+	key := "CORS_" + strings.ToUpper(group)
+	policy := cors.Policy{
+		AllowedOrigins: sys.GetEnvList(key+"_ORIGINS", defaults.AllowedOrigins),
+		AllowedMethods: sys.GetEnvList(key+"_METHODS", defaults.AllowedMethods),
+		AllowedHeaders: sys.GetEnvList(key+"_HEADERS", defaults.AllowedHeaders),
+		ExposedHeaders: sys.GetEnvList(key+"_EXPOSE", defaults.ExposedHeaders),
+		AllowCredentials: sys.GetEnvBool(key+"_CREDENTIALS", defaults.AllowCredentials),
+		MaxAge: sys.GetEnvDuration(key+"_MAX_AGE", defaults.MaxAge),
+	}
+	err := policy.Validate()
+	if err != nil {
+		log.Fatalf("%s: %v", key, err)
+	}
+	return policy
+}
 
+// minExportSecretLength is the shortest EXPORT_SIGNING_SECRET accepted
+const minExportSecretLength = 32
 
+// loadExportSigningKey returns the key export download urls are signed with.
+// It is a secret of its own, so it is set even when JWTs are signed with keys
+func loadExportSigningKey() ([]byte, error) {
+	secret := os.Getenv("EXPORT_SIGNING_SECRET")
+	if len(secret) < minExportSecretLength {
+		return nil, fmt.Errorf("EXPORT_SIGNING_SECRET must be set to at least %d characters", minExportSecretLength)
+	}
+	return []byte(secret), nil
+}
 
+// loadKeyring loads the JWT signing keys from JWT_KEYS_FILE.
+// Without it tokens are signed with JWT_SECRET only
+func loadKeyring() (*keyring.Keyring, error) {
+	if path := os.Getenv("JWT_KEYS_FILE"); path != "" {
+		return keyring.LoadFile(path)
+	}
 
+	key, err := keyring.NewHMACKey(keyring.DefaultKeyId, []byte(os.Getenv("JWT_SECRET")))
+	if err != nil {
+		return nil, err
+	}
+	return keyring.New(key)
+}